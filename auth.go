@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+)
+
+// Players launched from classicube.net present an mppass of
+// md5(salt + name), where salt is the one we sent in our heartbeat.  Anyone
+// else can claim any name they like.
+
+const (
+	VerifyNamesOff     = "off"
+	VerifyNamesWarn    = "warn"
+	VerifyNamesEnforce = "enforce"
+)
+
+const (
+	VerifyBypassNone      = "none"
+	VerifyBypassLocalhost = "localhost"
+	VerifyBypassLAN       = "lan"
+)
+
+const saltLength = 16
+
+const saltAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+var ErrInvalidMppass = errors.New("mppass does not match name")
+
+var lanNetworks = mustParseCIDRs(
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"169.254.0.0/16",
+	"fc00::/7",
+	"fe80::/10",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := []*net.IPNet{}
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+
+	return nets
+}
+
+// NewSalt generates a random salt to send in heartbeats.
+func NewSalt() (string, error) {
+	buf := make([]byte, saltLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	for i, b := range buf {
+		// 256 is not a multiple of len(saltAlphabet), but the bias is
+		// irrelevant here
+		buf[i] = saltAlphabet[int(b)%len(saltAlphabet)]
+	}
+
+	return string(buf), nil
+}
+
+func checkVerifyMode(mode, bypass string) error {
+	switch mode {
+	case VerifyNamesOff, VerifyNamesWarn, VerifyNamesEnforce:
+	default:
+		return fmt.Errorf("unknown name verification mode %q", mode)
+	}
+
+	switch bypass {
+	case VerifyBypassNone, VerifyBypassLocalhost, VerifyBypassLAN:
+	default:
+		return fmt.Errorf("unknown name verification bypass %q", bypass)
+	}
+
+	return nil
+}
+
+func verifyMppass(salt, name, mppass string) bool {
+	sum := md5.Sum([]byte(salt + name))
+	expected := hex.EncodeToString(sum[:])
+
+	// Some clients strip leading zeros from the hash
+	return strings.EqualFold(expected, mppass) ||
+		strings.EqualFold(strings.TrimLeft(expected, "0"), mppass)
+}
+
+// bypassesVerification returns whether a player connecting from addr is
+// trusted without an mppass.
+func bypassesVerification(bypass string, addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	switch bypass {
+	case VerifyBypassLocalhost:
+		return tcp.IP.IsLoopback()
+	case VerifyBypassLAN:
+		if tcp.IP.IsLoopback() {
+			return true
+		}
+		for _, n := range lanNetworks {
+			if n.Contains(tcp.IP) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// verifyName checks a player's mppass according to the server's
+// verification mode.  In warn mode a mismatch is only logged.
+func (s *Server) verifyName(name, mppass string, addr net.Addr) (verified bool, err error) {
+	if s.VerifyNames == VerifyNamesOff {
+		return false, nil
+	}
+	if verifyMppass(s.Salt, name, mppass) {
+		return true, nil
+	}
+	if bypassesVerification(s.VerifyBypass, addr) {
+		log.Printf("<%s> Allowing unverified name %s from trusted address", addr, name)
+		return false, nil
+	}
+
+	if s.VerifyNames == VerifyNamesEnforce {
+		return false, ErrInvalidMppass
+	}
+
+	log.Printf("<%s> [WARN] %s could not be verified: %s", addr, name, ErrInvalidMppass.Error())
+	return false, nil
+}
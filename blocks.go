@@ -0,0 +1,108 @@
+package main
+
+// Block IDs beyond obsidian aren't understood by Classic 0.30 clients, so
+// levels saved by servers with their own blocks are converted to the nearest
+// Classic block when they are loaded.
+
+// Fallbacks for the blocks added by the CPE CustomBlocks extension (50-65),
+// as defined by the extension itself.
+var customBlockFallback = [...]byte{
+	44, // Cobblestone slab -> slab
+	39, // Rope -> brown mushroom
+	12, // Sandstone -> sand
+	0,  // Snow -> air
+	11, // Fire -> still lava
+	33, // Light pink wool -> pink wool
+	25, // Forest green wool -> green wool
+	3,  // Brown wool -> dirt
+	29, // Deep blue wool -> blue wool
+	28, // Turquoise wool -> cyan wool
+	20, // Ice -> glass
+	42, // Ceramic tile -> iron
+	49, // Magma -> obsidian
+	36, // Pillar -> white wool
+	5,  // Crate -> wood
+	1,  // Stone brick -> stone
+}
+
+const firstCustomBlock = maxClassicBlock + 1
+
+// Physics and op blocks MCSharp (and MCGalaxy) store in .lvl files, and how
+// they look to clients, following MCSharp's Block.Convert
+var mcsharpBlocks = map[byte]byte{
+	70: 39, // CTF flag base -> brown mushroom
+	71: 36, // Falling snow -> white wool
+	72: 36, // Snow -> white wool
+	73: 11, // Fast death lava -> still lava
+	74: 46, // C4 -> TNT
+	75: 21, // C4 detonator -> red wool
+
+	100: 20, // op_glass
+	101: 49, // opsidian
+	102: 45, // op_brick
+	103: 1,  // op_stone
+	104: 4,  // op_cobblestone
+	105: 0,  // op_air
+	106: 9,  // op_water
+	107: 11, // op_lava
+	108: 1,  // Griefer stone
+	109: 19, // Lava sponge
+	110: 5,  // Floating wood
+	112: 11, // Fast lava
+
+	// Doors, which look like the block they are made of
+	111: 17, 113: 49, 114: 20, 115: 1, 116: 18,
+	117: 12, 118: 5, 119: 25, 120: 46, 121: 44,
+	// Toggle doors
+	122: 17, 123: 49, 124: 20, 125: 1, 126: 18,
+	127: 12, 128: 5, 129: 25, 135: 46, 136: 44,
+	137: 0, 138: 9, 139: 11,
+	// Other doors
+	148: 17, 149: 49, 150: 20, 151: 1, 152: 18,
+	153: 12, 154: 5, 155: 25, 156: 46, 157: 44,
+	158: 11, 159: 9,
+
+	// Message blocks
+	130: 36, 131: 34, 132: 0, 133: 9, 134: 11,
+
+	// Flowing and finite liquids
+	140: 8, 141: 10, 143: 9, 144: 11, 145: 8, 146: 10, 147: 9,
+
+	// Portals
+	160: 0, 161: 9, 162: 11, 175: 28, 176: 22,
+
+	// Explosives and fire
+	182: 46, 183: 46, 184: 11, 185: 11,
+
+	// Deadly blocks
+	190: 11, 191: 9, 192: 0, 193: 9, 194: 11,
+}
+
+// Shown for MCSharp blocks with no known equivalent
+const mcsharpUnknownBlock = 1
+
+// convertCustomBlocks replaces CustomBlocks IDs with their Classic fallbacks.
+// Other IDs are left for validation to report.
+func convertCustomBlocks(blocks []byte) {
+	for i, block := range blocks {
+		if block >= firstCustomBlock && int(block-firstCustomBlock) < len(customBlockFallback) {
+			blocks[i] = customBlockFallback[block-firstCustomBlock]
+		}
+	}
+}
+
+// convertMCSharpBlocks replaces every block a Classic client can't show.
+func convertMCSharpBlocks(blocks []byte) {
+	convertCustomBlocks(blocks)
+
+	for i, block := range blocks {
+		if block <= maxClassicBlock {
+			continue
+		}
+		if classic, ok := mcsharpBlocks[block]; ok {
+			blocks[i] = classic
+		} else {
+			blocks[i] = mcsharpUnknownBlock
+		}
+	}
+}
@@ -7,10 +7,8 @@ import (
 
 func readInt16(r io.Reader) (i int16, err error) {
 	tmp := make([]byte, 2)
-	n, err := r.Read(tmp)
-
-	if n != 2 {
-		return -1, errors.New("readInt16: read too short")
+	if _, err := io.ReadFull(r, tmp); err != nil {
+		return -1, err
 	}
 
 	return int16(tmp[0])<<8 | int16(tmp[1]), nil
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ClassicWorld (.cw) is the gzipped NBT level format used by ClassiCube,
+// MCGalaxy and others.  See https://wiki.vg/ClassicWorld_file_format
+//
+// Spawn coordinates are stored in blocks rather than player units, so they are
+// converted the same way as .dat spawns.
+
+const classicWorldRoot = "ClassicWorld"
+
+const classicWorldVersion = 1
+
+func init() {
+	RegisterLevelFormat(&LevelFormat{
+		Name:       LevelFormatClassicWorld,
+		Extensions: []string{".cw"},
+		Gzipped:    true,
+		Detect: func(header []byte) bool {
+			// Root compound tag named "ClassicWorld"
+			return len(header) >= 3+len(classicWorldRoot) &&
+				header[0] == nbtCompound &&
+				int(header[1])<<8|int(header[2]) == len(classicWorldRoot) &&
+				string(header[3:3+len(classicWorldRoot)]) == classicWorldRoot
+		},
+		Loader: LevelLoaderFunc(readClassicWorld),
+		Writer: LevelWriterFunc(writeClassicWorld),
+	})
+}
+
+func readClassicWorld(r io.Reader) (*Level, error) {
+	name, root, err := ReadNBT(r)
+	if err != nil {
+		return nil, err
+	}
+	if name != classicWorldRoot {
+		return nil, errors.New("readClassicWorld: root tag is not ClassicWorld")
+	}
+
+	if version, ok := root["FormatVersion"].(int8); !ok || version != classicWorldVersion {
+		return nil, fmt.Errorf("readClassicWorld: unsupported format version %v", root["FormatVersion"])
+	}
+
+	lvl := &Level{}
+	lvl.Width, _ = root["X"].(int16)
+	lvl.Depth, _ = root["Y"].(int16)
+	lvl.Height, _ = root["Z"].(int16)
+	if err := lvl.checkDimensions(); err != nil {
+		return nil, err
+	}
+
+	var ok bool
+	if lvl.Blocks, ok = root["BlockArray"].([]byte); !ok {
+		return nil, errors.New("readClassicWorld: missing BlockArray")
+	}
+	if len(lvl.Blocks) != lvl.Volume() {
+		return nil, errors.New("readClassicWorld: BlockArray does not match level size")
+	}
+	// ClassiCube and MCGalaxy save CustomBlocks IDs, which aren't negotiated
+	convertCustomBlocks(lvl.Blocks)
+
+	lvl.Name, _ = root["Name"].(string)
+	lvl.UUID, _ = root["UUID"].([]byte)
+	if createdBy, ok := root["CreatedBy"].(NBTCompound); ok {
+		lvl.Creator, _ = createdBy["Username"].(string)
+	}
+	if created, ok := root["TimeCreated"].(int64); ok && created > 0 {
+		lvl.CreateTime = time.Unix(created, 0)
+	}
+
+	if spawn, ok := root["Spawn"].(NBTCompound); ok {
+		x, _ := spawn["X"].(int16)
+		y, _ := spawn["Y"].(int16)
+		z, _ := spawn["Z"].(int16)
+		h, _ := spawn["H"].(int8)
+		p, _ := spawn["P"].(int8)
+		lvl.Spawn = Spawnpoint{
+			X:    x<<5 + 16,
+			Y:    y << 5,
+			Z:    z<<5 + 16,
+			RotX: byte(h),
+			RotY: byte(p),
+		}
+	} else {
+		lvl.Spawn = lvl.defaultSpawn()
+	}
+
+	return lvl, nil
+}
+
+func writeClassicWorld(w io.Writer, lvl *Level) error {
+	uuid, err := lvl.uuid()
+	if err != nil {
+		return err
+	}
+
+	root := NBTCompound{
+		"FormatVersion": int8(classicWorldVersion),
+		"Name":          lvl.Name,
+		"UUID":          uuid,
+		"X":             lvl.Width,
+		"Y":             lvl.Depth,
+		"Z":             lvl.Height,
+		"Spawn": NBTCompound{
+			"X": lvl.Spawn.X >> 5,
+			"Y": lvl.Spawn.Y >> 5,
+			"Z": lvl.Spawn.Z >> 5,
+			"H": int8(lvl.Spawn.RotX),
+			"P": int8(lvl.Spawn.RotY),
+		},
+		"BlockArray": lvl.Blocks,
+		"Metadata":   NBTCompound{},
+	}
+
+	if lvl.Creator != "" {
+		root["CreatedBy"] = NBTCompound{
+			"Service":  "",
+			"Username": lvl.Creator,
+		}
+	}
+	if !lvl.CreateTime.IsZero() {
+		root["TimeCreated"] = lvl.CreateTime.Unix()
+	}
+
+	return WriteNBT(w, classicWorldRoot, root)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestClassicWorldCustomBlocksConverted(t *testing.T) {
+	blocks := []byte{50, 52, 60, 65, 3}
+	want := []byte{44, 12, 20, 1, 3}
+
+	lvl := &Level{Width: int16(len(blocks)), Depth: 1, Height: 1, Blocks: blocks}
+	buf := new(bytes.Buffer)
+	if err := writeClassicWorld(buf, lvl); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readClassicWorld(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Blocks, want) {
+		t.Fatalf("got blocks %v, want %v", got.Blocks, want)
+	}
+}
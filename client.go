@@ -2,7 +2,6 @@ package main
 
 import (
 	"bytes"
-	"compress/gzip"
 	"errors"
 	"fmt"
 	"io"
@@ -10,31 +9,87 @@ import (
 	"math/rand"
 	"net"
 	"strings"
+	"sync"
+	"time"
 )
 
 var ErrInvalidMessage = errors.New("invalid message")
 
+// Time allowed between connecting and finishing the login (including CPE
+// negotiation)
+const handshakeTimeout = 10 * time.Second
+
 type Client struct {
 	conn    net.Conn
 	encoder *ServerEncoder
 	decoder *ClientDecoder
 	museum  *Museum
+	server  *Server
 
+	connectTime    time.Time
 	name           string
+	clientSoftware string
+	// Whether name was checked against the mppass
+	verified       bool
+	extensions     map[string]int32
 	warnedSetBlock bool
+
+	// Only accessed from MainLoop
+	room         *Room
+	entityId     int8
+	lastActive   time.Time
+	lastPosition SetPosition
+
+	levelMutex sync.Mutex
+	level      string
+
+	// See outbox.go
+	outbox      chan outgoing
+	done        chan struct{}
+	writerDone  chan struct{}
+	closeOnce   sync.Once
+	entityMutex sync.Mutex
+	entities    *entityBatch
+}
+
+func NewClient(conn net.Conn, server *Server) *Client {
+	return &Client{
+		conn:        conn,
+		encoder:     NewServerEncoder(conn),
+		decoder:     NewClientDecoder(conn),
+		museum:      server.museum,
+		server:      server,
+		connectTime: time.Now(),
+		outbox:      make(chan outgoing, outboxSize),
+		done:        make(chan struct{}),
+		writerDone:  make(chan struct{}),
+	}
 }
 
 func (c *Client) MainLoop() {
+	go c.writeLoop()
 	defer func() {
 		c.log("Closing connection")
-		c.conn.Close()
+		c.close()
 	}()
 
+	// Don't let connections that never log in tie up a goroutine
+	c.conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
 	err := c.handshake()
 	if err != nil {
 		c.log("[ERROR] handshake failed: %s", err.Error())
 		return
 	}
+	c.conn.SetReadDeadline(time.Time{})
+	go c.pingLoop()
+
+	if err := c.server.register(c); err != nil {
+		c.log("Refusing %s: %s", c.name, err.Error())
+		c.Kick("Server is full")
+		return
+	}
+	defer c.server.unregister(c)
+	defer c.leaveRoom()
 
 	level, err := c.museum.GetDefaultLevel()
 	if err != nil {
@@ -51,122 +106,232 @@ func (c *Client) MainLoop() {
 
 	c.about()
 
+	idleTimeout := c.server.IdleTimeout
+	c.lastActive = time.Now()
 	for {
-		packetId, err := c.decoder.NextPacketID()
-		if err == io.EOF {
-			// Close quietly on EOF
+		if idleTimeout > 0 {
+			c.conn.SetReadDeadline(c.lastActive.Add(idleTimeout))
+		}
+
+		packet, err := c.decoder.ReadPacket()
+		if err == io.EOF || c.disconnected() {
+			// Close quietly on EOF, or if the writer already gave up
+			return
+		} else if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+			c.kickIdle()
 			return
 		} else if err != nil {
 			c.log("[ERROR] read failed: %s", err.Error())
 			return
 		}
 
-		switch packetId {
-		case PacketClientHello:
-			_, _, err = c.decoder.ReadClientHello()
-		case PacketClientSetBlock:
-			_, _, _, _, _, err = c.decoder.ReadSetBlock()
+		switch p := packet.(type) {
+		case *ClientHello:
+		case *ClientSetBlock:
 			if !c.warnedSetBlock {
 				c.SendMessage("This server is a view-only archive of old levels.  Your changes won't be saved", MessageSenderServer)
 				c.warnedSetBlock = true
 			}
-		case PacketClientPositionUpdate:
-			_, _, _, _, _, err = c.decoder.ReadPositionUpdate()
-		case PacketClientMessage:
-			message, err := c.decoder.ReadMessage()
-			if err == nil {
-				if message[0] == '/' {
-					c.handleCommand(message)
-				} else {
-					c.SendMessage("Chat is disabled for this server", MessageSenderServer)
-				}
+		case *SetPosition:
+			// Clients send their position constantly, even when standing
+			// still
+			if *p != c.lastPosition {
+				c.lastPosition = *p
+				c.lastActive = time.Now()
+			}
+			if c.room != nil {
+				c.room.Move(c.entityId, p.X, p.Y, p.Z, p.Yaw, p.Pitch)
+			}
+		case *Message:
+			c.lastActive = time.Now()
+			if p.Message == "" {
+				break
+			}
+			if p.Message[0] == '/' {
+				c.handleCommand(p.Message)
+			} else {
+				c.SendMessage("Chat is disabled for this server", MessageSenderServer)
 			}
+		case *CustomBlockSupportLevel, *PlayerClicked, *TwoWayPing:
+			// Their extensions aren't offered, so there's nothing to do
 		default:
-			err = errors.New("Unhandled packet ID")
+			c.log("[ERROR] unexpected packet 0x%02x", packet.ID())
+			return
 		}
 
-		if err != nil {
-			c.log("[ERROR] failed to decode client packet: %s", err.Error())
+		if idleTimeout > 0 && time.Since(c.lastActive) > idleTimeout {
+			c.kickIdle()
 			return
 		}
 	}
 }
 
+func (c *Client) kickIdle() {
+	c.log("Kicking %s for inactivity", c.name)
+	c.Kick("Kicked for inactivity")
+}
+
 func (c *Client) handshake() error {
-	packetId, err := c.decoder.NextPacketID()
+	packet, err := c.decoder.ReadPacket()
 	if err != nil {
 		return err
-	} else if packetId != PacketClientHello {
+	}
+
+	hello, ok := packet.(*ClientHello)
+	if !ok {
 		return errors.New("expected ClientHello")
+	} else if hello.ProtocolVersion != ProtocolVersionClassic30 {
+		return errors.New("invalid protocol version")
 	}
+	name := hello.Name
 
-	name, _, err := c.decoder.ReadClientHello()
+	c.verified, err = c.server.verifyName(name, hello.Mppass, c.conn.RemoteAddr())
 	if err != nil {
-		return err
-	} else {
-		c.log("Logged in as %s", name)
-		c.name = name
+		c.Kick("Login failed!  Close the game and sign in again.")
+		return fmt.Errorf("failed to verify %s: %s", name, err.Error())
+	}
+
+	c.log("Logged in as %s", name)
+	c.name = name
+
+	c.extensions = make(map[string]int32)
+	if hello.SupportsCPE() {
+		if err = c.negotiateExtensions(); err != nil {
+			return err
+		}
 	}
 
-	return c.encoder.WriteServerHello(
-		c.museum.Name,
-		c.museum.MOTD,
-		PlayerTypeAdmin)
+	name, motd := c.museum.Name, c.museum.MOTD
+	c.queue(func(enc *ServerEncoder) error {
+		return enc.WriteServerHello(name, motd, PlayerTypeAdmin)
+	})
+
+	return nil
 }
 
 func (c *Client) SendLevel(level LevelDescriptor) error {
-	lvl, err := ReadLevel(level.Path)
+	cached, level, err := c.museum.LoadLevel(level)
 	if err != nil {
 		return err
 	}
 
-	buf := new(bytes.Buffer)
-	gzout := gzip.NewWriter(buf)
-	defer gzout.Close()
-
-	if err = writeInt32(gzout, len(lvl.Blocks)); err != nil {
-		return err
+	spawn := cached.Level.Spawn
+	if level.Spawn != nil {
+		spawn = *level.Spawn
 	}
 
-	if _, err = io.Copy(gzout, bytes.NewReader(lvl.Blocks)); err != nil {
-		return err
+	c.leaveRoom()
+	c.sendLevelData(cached, spawn)
+	c.sendEnvironment(level.Environment)
+	c.joinRoom(level.Name, spawn)
+
+	c.levelMutex.Lock()
+	c.level = level.Name
+	c.levelMutex.Unlock()
+
+	banner := fmt.Sprintf("This level is &c%s&e, from %s", level.Name, level.Date())
+	if level.Author != "" {
+		banner += fmt.Sprintf(", by &c%s", level.Author)
+	}
+	if level.Server != "" {
+		banner += fmt.Sprintf("&e on &c%s", level.Server)
 	}
+	c.SendMessage(banner, MessageSenderServer)
 
-	if err = gzout.Flush(); err != nil {
-		return err
+	if level.Description != "" {
+		c.SendMessage(level.Description, MessageSenderServer)
+	}
+	if len(level.Tags) > 0 {
+		c.SendMessage("Tags: "+strings.Join(level.Tags, ", "), MessageSenderServer)
 	}
+	return nil
+}
 
-	mapb := buf.Bytes()
+// sendLevelData queues the whole level as a single send, so that it can't
+// overflow the outbox.
+func (c *Client) sendLevelData(cached *CachedLevel, spawn Spawnpoint) {
+	name := c.name
+	fastMap := c.SupportsExtension(ExtFastMap)
 
-	if err = c.encoder.WriteLevelInit(); err != nil {
-		return err
-	}
+	c.queue(func(enc *ServerEncoder) error {
+		lvl := cached.Level
+		var mapb []byte
+		var err error
+
+		if fastMap {
+			if mapb, err = cached.FastMapPayload(); err != nil {
+				return err
+			}
+			if err := enc.WriteLevelInitFastMap(len(lvl.Blocks)); err != nil {
+				return err
+			}
+		} else {
+			if mapb, err = cached.Payload(); err != nil {
+				return err
+			}
+			if err := enc.WriteLevelInit(); err != nil {
+				return err
+			}
+		}
+
+		for i := 0; i < len(mapb); i += 1024 {
+			length := len(mapb) - i
+			if length > 1024 {
+				length = 1024
+			}
 
-	for i := 0; i < len(mapb); i += 1024 {
-		length := len(mapb) - i
-		if length > 1024 {
-			length = 1024
+			if err := enc.WriteLevelDataChunk(mapb[i:i+length], i, len(mapb)); err != nil {
+				return err
+			}
 		}
 
-		if err := c.encoder.WriteLevelDataChunk(mapb[i:i+length], i, len(mapb)); err != nil {
+		if err := enc.WriteLevelFinalize(lvl.Width, lvl.Depth, lvl.Height); err != nil {
 			return err
 		}
-	}
 
-	if err = c.encoder.WriteLevelFinalize(lvl.Width, lvl.Depth, lvl.Height); err != nil {
-		return err
+		return enc.WriteSpawnPlayer(-1, name, spawn.X, spawn.Y, spawn.Z, spawn.RotX, spawn.RotY)
+	})
+}
+
+// ClientInfo describes a logged in client, for listing who is online.
+type ClientInfo struct {
+	Name        string
+	Address     string
+	Level       string
+	ConnectTime time.Time
+	Verified    bool
+}
+
+func (c *Client) Info() ClientInfo {
+	c.levelMutex.Lock()
+	defer c.levelMutex.Unlock()
+
+	return ClientInfo{
+		Name:        c.name,
+		Address:     c.conn.RemoteAddr().String(),
+		Level:       c.level,
+		ConnectTime: c.connectTime,
+		Verified:    c.verified,
 	}
+}
 
-	if err = c.encoder.WriteSpawnPlayer(-1, c.name, lvl.Spawn.X, lvl.Spawn.Y, lvl.Spawn.Z, 0, 0); err != nil {
-		return err
+func (c *Client) joinRoom(level string, spawn Spawnpoint) {
+	room := c.museum.Room(level)
+	id, err := room.Join(c, spawn)
+	if err != nil {
+		c.log("[ERROR] Failed to join room for %s: %s", level, err.Error())
+		return
 	}
 
-	c.SendMessage(fmt.Sprintf(
-		"This level is &c%s&e, from %s",
-		level.Name,
-		level.Datestring),
-		MessageSenderServer)
-	return nil
+	c.room = room
+	c.entityId = id
+}
+
+func (c *Client) leaveRoom() {
+	if c.room != nil {
+		c.room.Leave(c.entityId)
+		c.room = nil
+	}
 }
 
 func (c *Client) SendMessage(message string, sender int8) {
@@ -176,6 +341,7 @@ func (c *Client) SendMessage(message string, sender int8) {
 		return
 	}
 
+	lines := []string{}
 	for len(mbytes) > 64 {
 		line := mbytes[:64]
 		if x := bytes.LastIndexByte(line, ' '); x > 0 {
@@ -185,24 +351,37 @@ func (c *Client) SendMessage(message string, sender int8) {
 			line = line[:len(line)-1]
 		}
 
-		err := c.encoder.WriteMessage(string(line), sender)
-		if err != nil {
-			c.log("[ERROR] Message send failed: %s", err.Error())
-			return
-		}
-
+		lines = append(lines, string(line))
 		mbytes = append([]byte("> "), mbytes[len(line):]...)
 	}
 
 	if string(mbytes) != "> " {
-		if err := c.encoder.WriteMessage(string(mbytes), sender); err != nil {
-			log.Printf("[ERROR] Message send failed: %s", err.Error())
-		}
+		lines = append(lines, string(mbytes))
 	}
+
+	c.queue(func(enc *ServerEncoder) error {
+		for _, line := range lines {
+			if err := enc.WriteMessage(line, sender); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
 }
 
-func (c *Client) Kick(reason string) error {
-	return c.encoder.WriteKick(reason)
+// Kick sends the client a disconnect message, cut to the 64 bytes a Kick
+// packet can carry.  The connection is closed once MainLoop returns.
+func (c *Client) Kick(reason string) {
+	if len(reason) > 64 {
+		reason = reason[:64]
+		// Sending an incomplete color-code will crash the game
+		reason = strings.TrimSuffix(reason, "&")
+	}
+
+	c.queue(func(enc *ServerEncoder) error {
+		return enc.WriteKick(reason)
+	})
 }
 
 func (c *Client) handleCommand(message string) {
@@ -215,6 +394,7 @@ func (c *Client) handleCommand(message string) {
 		c.SendMessage("- &c/levels&e: list available levels", MessageSenderServer)
 		c.SendMessage("- &c/goto <levelname>&e: warp to another level", MessageSenderServer)
 		c.SendMessage("- &c/random&e: warp to a random level", MessageSenderServer)
+		c.SendMessage("- &c/who&e: list players and the levels they are visiting", MessageSenderServer)
 	case "/about":
 		c.about()
 	case "/levels":
@@ -237,6 +417,10 @@ func (c *Client) handleCommand(message string) {
 		}
 	case "/random":
 		levels := c.museum.ListLevelNames()
+		if len(levels) == 0 {
+			c.SendMessage("There are no levels to pick from", MessageSenderServer)
+			return
+		}
 		index := rand.Intn(len(levels))
 		level, err := c.museum.GetLevel(levels[index])
 		if err != nil {
@@ -251,6 +435,13 @@ func (c *Client) handleCommand(message string) {
 		} else {
 			c.log("Visiting level %s", level.Name)
 		}
+	case "/who":
+		players := []string{}
+		for _, other := range c.server.Clients() {
+			info := other.Info()
+			players = append(players, fmt.Sprintf("%s (&c%s&e)", info.Name, info.Level))
+		}
+		c.SendMessage(fmt.Sprintf("%d online: %s", len(players), strings.Join(players, ", ")), MessageSenderServer)
 	default:
 		c.SendMessage("Unknown command &c"+args[0], MessageSenderServer)
 	}
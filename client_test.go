@@ -0,0 +1,24 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestKickTruncatesReason(t *testing.T) {
+	buf := new(bytes.Buffer)
+	c := newTestClient(buf)
+
+	c.Kick(strings.Repeat("a", 63) + "&cb")
+	c.Kick(strings.Repeat("b", 70))
+
+	want := []Packet{
+		&Kick{strings.Repeat("a", 63)},
+		&Kick{strings.Repeat("b", 64)},
+	}
+	if got := drainOutbox(t, c, buf); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
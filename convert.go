@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Level details each format can store, besides the blocks.  Anything else is
+// lost in conversion.
+var levelFormatFields = map[string][]string{
+	LevelFormatDump:         {"spawn"},
+	LevelFormatDat:          {"name", "creator", "created"},
+	LevelFormatClassicWorld: {"spawn", "rotation", "name", "creator", "created", "uuid"},
+	LevelFormatMCSharp:      {"spawn", "rotation"},
+	LevelFormatFCraft:       {"spawn", "rotation", "name", "creator", "created", "uuid"},
+}
+
+var levelFields = []string{"spawn", "rotation", "name", "creator", "created", "uuid"}
+
+// lostFields lists the details of lvl that can't be saved in format.
+func lostFields(lvl *Level, format string) []string {
+	present := map[string]bool{
+		"spawn":    lvl.Spawn != lvl.defaultSpawn(),
+		"rotation": lvl.Spawn.RotX != 0 || lvl.Spawn.RotY != 0,
+		"name":     lvl.Name != "",
+		"creator":  lvl.Creator != "",
+		"created":  !lvl.CreateTime.IsZero(),
+		"uuid":     len(lvl.UUID) == 16,
+	}
+	for _, field := range levelFormatFields[format] {
+		delete(present, field)
+	}
+
+	lost := []string{}
+	for _, field := range levelFields {
+		if present[field] {
+			lost = append(lost, field)
+		}
+	}
+
+	return lost
+}
+
+type converter struct {
+	from      string
+	to        *LevelFormat
+	dryRun    bool
+	overwrite bool
+
+	// Output files so far, so that two inputs with the same name (say,
+	// world.dat and world.lvl) don't end up overwriting each other
+	outputs map[string]string
+
+	converted int
+	failed    int
+}
+
+// convert converts a single file, printing a line about what it did.
+func (c *converter) convert(input, output string) {
+	to := c.to
+	if to == nil {
+		if to = LevelFormatForFilename(output); to == nil {
+			fmt.Printf("%s: can't tell output format from extension, use -to\n", output)
+			c.failed++
+			return
+		}
+	}
+
+	err := c.convertLevel(input, output, to)
+	if err != nil {
+		fmt.Printf("%s: %s\n", input, err.Error())
+		c.failed++
+		return
+	}
+
+	c.converted++
+}
+
+func (c *converter) convertLevel(input, output string, to *LevelFormat) error {
+	if to.Writer == nil {
+		return fmt.Errorf("writing %s levels is not supported", to.Name)
+	}
+	in, _ := filepath.Abs(input)
+	out, _ := filepath.Abs(output)
+	if in == out {
+		return errors.New("input and output are the same file")
+	}
+	if previous, ok := c.outputs[out]; ok {
+		return fmt.Errorf("%s is also the output for %s", output, previous)
+	}
+	c.outputs[out] = input
+	if _, err := os.Stat(output); err == nil && !c.overwrite {
+		return fmt.Errorf("%s already exists, use -overwrite", output)
+	}
+
+	lvl, err := ReadLevelFormat(input, c.from)
+	if err != nil {
+		return err
+	}
+
+	report := fmt.Sprintf("%s -> %s (%s, %d x %d x %d)", input, output, to.Name, lvl.Width, lvl.Depth, lvl.Height)
+	if lost := lostFields(lvl, to.Name); len(lost) > 0 {
+		report += ", dropping " + strings.Join(lost, ", ")
+	}
+
+	if !c.dryRun {
+		if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+			return err
+		}
+		if err := WriteLevelFormat(output, lvl, to.Name); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println(report)
+	return nil
+}
+
+// convertDir converts every level found under dir into the same layout under
+// outputDir.  Files that aren't levels are skipped.
+func (c *converter) convertDir(dir, outputDir string) error {
+	// Don't convert our own output if it's inside dir
+	outputAbs, err := filepath.Abs(outputDir)
+	if err != nil {
+		return err
+	}
+
+	src := &ScanSource{Dirs: []string{dir}}
+	return src.walk(func(path string, info os.FileInfo) {
+		if abs, _ := filepath.Abs(path); strings.HasPrefix(abs, outputAbs+string(filepath.Separator)) {
+			return
+		}
+
+		if c.from == "" {
+			file, err := os.Open(path)
+			if err != nil {
+				fmt.Printf("%s: %s\n", path, err.Error())
+				c.failed++
+				return
+			}
+			_, err = DetectLevelFormat(path, file)
+			file.Close()
+			if err != nil {
+				fmt.Printf("Skipping %s: %s\n", path, err.Error())
+				return
+			}
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			fmt.Printf("%s: %s\n", path, err.Error())
+			c.failed++
+			return
+		}
+		output := filepath.Join(outputDir, strings.TrimSuffix(rel, filepath.Ext(rel))+c.to.Extensions[0])
+
+		c.convert(path, output)
+	})
+}
+
+func runConvert(args []string) error {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	from := flags.String("from", "", "Input level format (detected if not set)")
+	to := flags.String("to", "", "Output level format (from the output extension if not set; required for directories)")
+	dryRun := flags.Bool("n", false, "Report what would be converted without writing anything")
+	overwrite := flags.Bool("overwrite", false, "Replace existing output files")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s convert [flags] input output\n", os.Args[0])
+		fmt.Fprintf(flags.Output(), "If input is a directory, every level under it is converted into the output directory.\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return errors.New("expected an input and an output")
+	}
+	input, output := flags.Arg(0), flags.Arg(1)
+
+	c := &converter{
+		from:      *from,
+		dryRun:    *dryRun,
+		overwrite: *overwrite,
+		outputs:   make(map[string]string),
+	}
+	if *from != "" && !IsLevelFormat(*from) {
+		return fmt.Errorf("unknown level format %s", *from)
+	}
+	if *to != "" {
+		if c.to = GetLevelFormat(*to); c.to == nil {
+			return fmt.Errorf("unknown level format %s", *to)
+		}
+		if c.to.Writer == nil || len(c.to.Extensions) == 0 {
+			return fmt.Errorf("writing %s levels is not supported", *to)
+		}
+	}
+
+	info, err := os.Stat(input)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		if c.to == nil {
+			return errors.New("-to is required when converting a directory")
+		}
+		if err := c.convertDir(input, output); err != nil {
+			return err
+		}
+	} else {
+		c.convert(input, output)
+	}
+
+	verb := "Converted"
+	if c.dryRun {
+		verb = "Would convert"
+	}
+	fmt.Printf("%s %d levels (%d failed)\n", verb, c.converted, c.failed)
+
+	if c.failed > 0 {
+		return fmt.Errorf("%d levels could not be converted", c.failed)
+	}
+
+	return nil
+}
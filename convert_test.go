@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func convertTestLevel() *Level {
+	lvl := &Level{Width: 16, Depth: 8, Height: 12}
+	lvl.Blocks = make([]byte, lvl.Volume())
+	for i := range lvl.Blocks {
+		lvl.Blocks[i] = byte(i % (maxClassicBlock + 1))
+	}
+
+	// Block aligned, since some formats store spawns in blocks
+	lvl.Spawn = Spawnpoint{X: 3<<5 + 16, Y: 5 << 5, Z: 9<<5 + 16, RotX: 64, RotY: 32}
+	lvl.Name = "Test World"
+	lvl.Creator = "notch"
+	lvl.CreateTime = time.Unix(1251234567, 0)
+	lvl.UUID = []byte("0123456789abcdef")
+
+	return lvl
+}
+
+// keptField reports whether field survived being written and read back.
+func keptField(orig, got *Level, field string) bool {
+	switch field {
+	case "spawn":
+		return orig.Spawn.X == got.Spawn.X && orig.Spawn.Y == got.Spawn.Y && orig.Spawn.Z == got.Spawn.Z
+	case "rotation":
+		return orig.Spawn.RotX == got.Spawn.RotX && orig.Spawn.RotY == got.Spawn.RotY
+	case "name":
+		return orig.Name == got.Name
+	case "creator":
+		return orig.Creator == got.Creator
+	case "created":
+		return orig.CreateTime.Equal(got.CreateTime)
+	case "uuid":
+		return bytes.Equal(orig.UUID, got.UUID)
+	}
+
+	return false
+}
+
+func TestLevelFormatsRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "convert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, format := range levelFormats {
+		if format.Writer == nil {
+			continue
+		}
+
+		tests := []struct {
+			filename string
+			name     string
+		}{
+			// Format picked from the extension, and detected when read
+			{"level" + format.Extensions[0], ""},
+			// No extension, so the format has to be named when written
+			{"level", format.Name},
+		}
+
+		for _, test := range tests {
+			orig := convertTestLevel()
+			path := filepath.Join(dir, format.Name+"-"+test.filename)
+			if err := WriteLevelFormat(path, orig, test.name); err != nil {
+				t.Errorf("%s: %v", path, err)
+				continue
+			}
+			got, err := ReadLevelFormat(path, test.name)
+			if err != nil {
+				t.Errorf("%s: %v", path, err)
+				continue
+			}
+
+			if got.Width != orig.Width || got.Depth != orig.Depth || got.Height != orig.Height {
+				t.Errorf("%s: got %d x %d x %d, want %d x %d x %d", path,
+					got.Width, got.Depth, got.Height, orig.Width, orig.Depth, orig.Height)
+				continue
+			}
+			if !reflect.DeepEqual(got.Blocks, orig.Blocks) {
+				t.Errorf("%s: blocks changed", path)
+			}
+
+			lost := map[string]bool{}
+			for _, field := range lostFields(orig, format.Name) {
+				lost[field] = true
+			}
+			for _, field := range levelFields {
+				if kept := keptField(orig, got, field); kept == lost[field] {
+					t.Errorf("%s: %s kept = %v, but lostFields says lost = %v", path, field, kept, lost[field])
+				}
+			}
+			if lost["spawn"] && got.Spawn != got.defaultSpawn() {
+				t.Errorf("%s: got spawn %+v, want the default", path, got.Spawn)
+			}
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import "errors"
+
+// Classic Protocol Extension support.  See https://wiki.vg/Classic_Protocol_Extension
+
+const cpeAppName = "mcmuseum"
+
+const (
+	ExtFastMap   = "FastMap"
+	ExtEnvColors = "EnvColors"
+)
+
+type Extension struct {
+	Name    string
+	Version int32
+}
+
+// Extensions supported by this server, advertised to every CPE client
+var serverExtensions = []Extension{
+	{ExtFastMap, 1},
+	{ExtEnvColors, 1},
+}
+
+// negotiateExtensions exchanges ExtInfo/ExtEntry packets with a client that
+// signalled CPE support in its ClientHello, and records the extensions both
+// sides support.
+func (c *Client) negotiateExtensions() error {
+	c.queue(func(enc *ServerEncoder) error {
+		if err := enc.WriteExtInfo(cpeAppName, int16(len(serverExtensions))); err != nil {
+			return err
+		}
+		for _, ext := range serverExtensions {
+			if err := enc.WriteExtEntry(ext.Name, ext.Version); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	packet, err := c.decoder.ReadPacket()
+	if err != nil {
+		return err
+	}
+	info, ok := packet.(*ExtInfo)
+	if !ok {
+		return errors.New("expected ExtInfo")
+	}
+	c.clientSoftware = info.AppName
+
+	for i := int16(0); i < info.ExtensionCount; i++ {
+		packet, err := c.decoder.ReadPacket()
+		if err != nil {
+			return err
+		}
+		entry, ok := packet.(*ExtEntry)
+		if !ok {
+			return errors.New("expected ExtEntry")
+		}
+
+		for _, ext := range serverExtensions {
+			if ext.Name == entry.ExtName && ext.Version == entry.Version {
+				c.extensions[entry.ExtName] = entry.Version
+			}
+		}
+	}
+
+	c.log("Client is %s, with %d extensions (%d enabled)", info.AppName, info.ExtensionCount, len(c.extensions))
+	return nil
+}
+
+// SupportsExtension reports whether the extension was negotiated for this
+// connection.  It is always false for vanilla clients.
+func (c *Client) SupportsExtension(name string) bool {
+	_, ok := c.extensions[name]
+	return ok
+}
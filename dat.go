@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Minecraft Classic .dat levels are gzipped, and start with a magic number
+// and a format version.  Version 1 (Classic 0.0.13a and earlier) is a plain
+// header followed by the block array; version 2 is a serialized
+// com.mojang.minecraft.level.Level object.
+
+const datMagic = 0x271bb788
+
+const datLevelClass = "com.mojang.minecraft.level.Level"
+
+func init() {
+	RegisterLevelFormat(&LevelFormat{
+		Name:       LevelFormatDat,
+		Extensions: []string{".dat", ".mine"},
+		Gzipped:    true,
+		Detect: func(header []byte) bool {
+			return len(header) >= 4 && binary.BigEndian.Uint32(header) == datMagic
+		},
+		Loader: LevelLoaderFunc(readDatLevel),
+		Writer: LevelWriterFunc(writeDatLevel),
+	})
+}
+
+func readDatLevel(r io.Reader) (*Level, error) {
+	var header struct {
+		Magic   uint32
+		Version byte
+	}
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, err
+	}
+	if header.Magic != datMagic {
+		return nil, errors.New("readDatLevel: bad magic number")
+	}
+
+	switch header.Version {
+	case 1:
+		return readDatLevelV1(r)
+	case 2:
+		return readDatLevelV2(r)
+	default:
+		return nil, fmt.Errorf("readDatLevel: unsupported version %d", header.Version)
+	}
+}
+
+func readDatLevelV1(r io.Reader) (*Level, error) {
+	dec := &javaDecoder{r: r}
+
+	name, err := dec.readUTF()
+	if err != nil {
+		return nil, err
+	}
+	creator, err := dec.readUTF()
+	if err != nil {
+		return nil, err
+	}
+
+	var header struct {
+		CreateTime int64
+		Width      int16
+		Height     int16
+		Depth      int16
+	}
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, err
+	}
+
+	lvl := &Level{
+		Width:      header.Width,
+		Depth:      header.Depth,
+		Height:     header.Height,
+		Name:       name,
+		Creator:    creator,
+		CreateTime: javaTime(header.CreateTime),
+	}
+	if err := lvl.checkDimensions(); err != nil {
+		return nil, err
+	}
+
+	lvl.Blocks = make([]byte, lvl.Volume())
+	if _, err := io.ReadFull(r, lvl.Blocks); err != nil {
+		return nil, err
+	}
+
+	// Version 1 levels don't store a spawn point
+	lvl.Spawn = lvl.defaultSpawn()
+
+	return lvl, nil
+}
+
+func readDatLevelV2(r io.Reader) (*Level, error) {
+	content, err := readJavaObject(r)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, ok := content.(*javaObject)
+	if !ok || obj.class.name != datLevelClass {
+		return nil, errors.New("readDatLevel: stream does not contain a " + datLevelClass)
+	}
+
+	// Classic's depth is the vertical axis, same as ours
+	var dims [6]int32
+	for i, field := range []string{"width", "depth", "height", "xSpawn", "ySpawn", "zSpawn"} {
+		if dims[i], err = obj.intField(field); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, d := range dims[:3] {
+		if d <= 0 || d > 0x7fff {
+			return nil, fmt.Errorf("readDatLevel: invalid level dimension %d", d)
+		}
+	}
+
+	lvl := &Level{
+		Width:   int16(dims[0]),
+		Depth:   int16(dims[1]),
+		Height:  int16(dims[2]),
+		Name:    obj.stringField("name"),
+		Creator: obj.stringField("creator"),
+	}
+
+	if createTime, ok := obj.fields["createTime"].(int64); ok {
+		lvl.CreateTime = javaTime(createTime)
+	}
+
+	blocks, ok := obj.fields["blocks"].(*javaArray)
+	if !ok {
+		return nil, errors.New("readDatLevel: level has no block array")
+	}
+	if lvl.Blocks, ok = blocks.values.([]byte); !ok {
+		return nil, errors.New("readDatLevel: block array is not a byte[]")
+	}
+	if len(lvl.Blocks) != lvl.Volume() {
+		return nil, errors.New("readDatLevel: block array does not match level size")
+	}
+
+	// Block coordinates to player units,
+	// centered in the block horizontally
+	lvl.Spawn = Spawnpoint{
+		X: int16(dims[3]<<5 + 16),
+		Y: int16(dims[4] << 5),
+		Z: int16(dims[5]<<5 + 16),
+	}
+	if rot, ok := obj.fields["rotSpawn"].(float32); ok {
+		lvl.Spawn.RotX = degreesToByte(float64(rot))
+	}
+
+	return lvl, nil
+}
+
+// writeDatLevel saves a level in the version 1 format, since writing a
+// version 2 level would mean serializing Java objects.  Version 1 has no
+// spawn point.
+func writeDatLevel(w io.Writer, lvl *Level) error {
+	header := struct {
+		Magic   uint32
+		Version byte
+	}{datMagic, 1}
+	if err := binary.Write(w, binary.BigEndian, header); err != nil {
+		return err
+	}
+
+	for _, s := range []string{lvl.Name, lvl.Creator} {
+		if len(s) > 0xffff {
+			return errors.New("writeDatLevel: string too long")
+		}
+		if err := binary.Write(w, binary.BigEndian, uint16(len(s))); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, s); err != nil {
+			return err
+		}
+	}
+
+	dims := struct {
+		CreateTime int64
+		Width      int16
+		Height     int16
+		Depth      int16
+	}{
+		CreateTime: javaMillis(lvl.CreateTime),
+		Width:      lvl.Width,
+		Height:     lvl.Height,
+		Depth:      lvl.Depth,
+	}
+	if err := binary.Write(w, binary.BigEndian, dims); err != nil {
+		return err
+	}
+
+	_, err := w.Write(lvl.Blocks)
+	return err
+}
+
+func javaTime(millis int64) time.Time {
+	if millis <= 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(millis/1000, (millis%1000)*int64(time.Millisecond))
+}
+
+func javaMillis(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixNano() / int64(time.Millisecond)
+}
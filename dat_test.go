@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// testdata/classic-0.30.dat is a 16x8x12 level serialized the way Classic
+// 0.30 saves them: a com.mojang.minecraft.level.Level with all its fields,
+// including a BlockMap with nested lists.  testdata/classic-0.0.13a.dat is the
+// same level in the older version 1 format.
+
+func TestReadDatLevelV2(t *testing.T) {
+	lvl, err := ReadLevelFormat("testdata/classic-0.30.dat", LevelFormatDat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lvl.Width != 16 || lvl.Depth != 8 || lvl.Height != 12 {
+		t.Errorf("got %d x %d x %d, want 16 x 8 x 12", lvl.Width, lvl.Depth, lvl.Height)
+	}
+	if want := (Spawnpoint{X: 8<<5 + 16, Y: 6 << 5, Z: 5<<5 + 16, RotX: 128}); lvl.Spawn != want {
+		t.Errorf("got spawn %+v, want %+v", lvl.Spawn, want)
+	}
+	if lvl.Name != "A Nice World" || lvl.Creator != "notch" {
+		t.Errorf("got name %q by %q", lvl.Name, lvl.Creator)
+	}
+	if want := time.Unix(1251234567, 890*int64(time.Millisecond)); !lvl.CreateTime.Equal(want) {
+		t.Errorf("got createTime %v, want %v", lvl.CreateTime, want)
+	}
+	if block := lvl.Blocks[(5*12+5)*16+8]; block != 20 {
+		t.Errorf("got block %d at 8, 5, 5, want glass", block)
+	}
+}
+
+func TestReadDatLevelV1(t *testing.T) {
+	lvl, err := ReadLevelFormat("testdata/classic-0.0.13a.dat", LevelFormatDat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lvl.Width != 16 || lvl.Depth != 8 || lvl.Height != 12 {
+		t.Errorf("got %d x %d x %d, want 16 x 8 x 12", lvl.Width, lvl.Depth, lvl.Height)
+	}
+	// No spawn is stored, so it's on top of the middle of the level
+	if want := (Spawnpoint{X: 8<<5 + 16, Y: 6 << 5, Z: 6<<5 + 16}); lvl.Spawn != want {
+		t.Errorf("got spawn %+v, want %+v", lvl.Spawn, want)
+	}
+	if lvl.Name != "Old World" || lvl.Creator != "notch" {
+		t.Errorf("got name %q by %q", lvl.Name, lvl.Creator)
+	}
+	if want := time.Unix(1243000000, 0); !lvl.CreateTime.Equal(want) {
+		t.Errorf("got createTime %v, want %v", lvl.CreateTime, want)
+	}
+}
+
+// javaArrayStream returns a serialized array that claims to have length
+// elements, followed by only a few bytes of data.
+func javaArrayStream(class string, length int32) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, uint16(javaStreamMagic))
+	binary.Write(buf, binary.BigEndian, uint16(javaStreamVersion))
+	buf.Write([]byte{tcArray, tcClassDesc})
+	binary.Write(buf, binary.BigEndian, uint16(len(class)))
+	buf.WriteString(class)
+	binary.Write(buf, binary.BigEndian, int64(0))
+	buf.Write([]byte{scSerializable, 0, 0, tcEndBlockData, tcNull})
+	binary.Write(buf, binary.BigEndian, length)
+	buf.Write(make([]byte, 16))
+
+	return buf.Bytes()
+}
+
+func TestJavaArrayLengthBounded(t *testing.T) {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := readJavaObject(bytes.NewReader(javaArrayStream("[B", 0x7fffffff)))
+	runtime.ReadMemStats(&after)
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("byte array: got %v, want io.ErrUnexpectedEOF", err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 16<<20 {
+		t.Errorf("allocated %d bytes for a truncated array", allocated)
+	}
+
+	if _, err := readJavaObject(bytes.NewReader(javaArrayStream("[I", 1<<30))); err == nil {
+		t.Error("int array: expected an error for a huge length")
+	}
+}
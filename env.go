@@ -0,0 +1,92 @@
+package main
+
+import "sort"
+
+// Colors a client can change with the EnvColors extension
+const (
+	EnvColorSky = iota
+	EnvColorCloud
+	EnvColorFog
+	EnvColorAmbient
+	EnvColorDiffuse
+	envColorCount
+)
+
+const EnvironmentDay = "day"
+
+type envColor struct {
+	Red, Green, Blue int16
+}
+
+// The client's own default for a color
+var envColorDefault = envColor{-1, -1, -1}
+
+// Environment is a set of colors, indexed by EnvColor*.
+type Environment [envColorCount]envColor
+
+// Environment presets a manifest can give a level.  Levels without one get
+// the client defaults, like "day".
+var environments = map[string]Environment{
+	EnvironmentDay: {envColorDefault, envColorDefault, envColorDefault, envColorDefault, envColorDefault},
+	"night": {
+		EnvColorSky:     {20, 20, 50},
+		EnvColorCloud:   {40, 40, 60},
+		EnvColorFog:     {15, 15, 35},
+		EnvColorAmbient: {40, 40, 70},
+		EnvColorDiffuse: {90, 90, 130},
+	},
+	"sunset": {
+		EnvColorSky:     {250, 140, 70},
+		EnvColorCloud:   {255, 190, 150},
+		EnvColorFog:     {230, 150, 110},
+		EnvColorAmbient: {110, 80, 80},
+		EnvColorDiffuse: {230, 170, 140},
+	},
+	"overcast": {
+		EnvColorSky:     {150, 160, 170},
+		EnvColorCloud:   {120, 120, 125},
+		EnvColorFog:     {170, 175, 180},
+		EnvColorAmbient: envColorDefault,
+		EnvColorDiffuse: {200, 200, 200},
+	},
+}
+
+func IsEnvironment(name string) bool {
+	_, ok := environments[name]
+	return ok
+}
+
+// EnvironmentNames lists the presets, for error messages.
+func EnvironmentNames() []string {
+	names := []string{}
+	for name := range environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// sendEnvironment applies a level's environment preset.  It is sent for
+// every level, including ones without a preset, so that colors don't carry
+// over from the previous level.
+func (c *Client) sendEnvironment(name string) {
+	if !c.SupportsExtension(ExtEnvColors) {
+		return
+	}
+
+	env, ok := environments[name]
+	if !ok {
+		env = environments[EnvironmentDay]
+	}
+
+	c.queue(func(enc *ServerEncoder) error {
+		for variable, color := range env {
+			if err := enc.WriteEnvSetColor(byte(variable), color.Red, color.Green, color.Blue); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestSendEnvironment(t *testing.T) {
+	buf := new(bytes.Buffer)
+	c := newTestClient(buf)
+
+	// Vanilla clients get nothing
+	c.sendEnvironment("night")
+	if len(c.outbox) != 0 {
+		t.Fatal("environment sent to a client without EnvColors")
+	}
+
+	c.extensions = map[string]int32{ExtEnvColors: 1}
+	c.sendEnvironment("night")
+	c.sendEnvironment("")
+
+	want := []Packet{}
+	for variable, color := range environments["night"] {
+		want = append(want, &EnvSetColor{byte(variable), color.Red, color.Green, color.Blue})
+	}
+	for variable := 0; variable < envColorCount; variable++ {
+		want = append(want, &EnvSetColor{byte(variable), -1, -1, -1})
+	}
+	if got := drainOutbox(t, c, buf); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// fCraft .fcm files (version 3) are little endian.  The header is
+// uncompressed, and is followed by a DEFLATE stream containing metadata
+// key/value pairs and then the block array.  Spawn coordinates are stored in
+// player units, like ours.
+
+const fcmMagic = 0x0fc2af40
+
+const fcmRevision = 13
+
+// Size of the layer index, which is skipped since there is only ever a
+// block layer
+const fcmLayerIndexSize = 26
+
+func init() {
+	RegisterLevelFormat(&LevelFormat{
+		Name:       LevelFormatFCraft,
+		Extensions: []string{".fcm"},
+		Detect: func(header []byte) bool {
+			return len(header) >= 4 && binary.LittleEndian.Uint32(header) == fcmMagic
+		},
+		Loader: LevelLoaderFunc(readFCraftLevel),
+		Writer: LevelWriterFunc(writeFCraftLevel),
+	})
+}
+
+func readFCraftLevel(r io.Reader) (*Level, error) {
+	var header struct {
+		Magic        uint32
+		Revision     byte
+		Width        uint16
+		Depth        uint16
+		Height       uint16
+		Spawn        Spawnpoint
+		DateModified uint32
+		DateCreated  uint32
+		GUID         [16]byte
+		LayerIndex   [fcmLayerIndexSize]byte
+		MetaCount    int32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+
+	if header.Magic != fcmMagic {
+		return nil, errors.New("readFCraftLevel: unsupported .fcm version")
+	}
+	if header.Revision != fcmRevision {
+		return nil, fmt.Errorf("readFCraftLevel: unsupported revision %d", header.Revision)
+	}
+	if header.Width > 0x7fff || header.Depth > 0x7fff || header.Height > 0x7fff {
+		return nil, errors.New("readFCraftLevel: level too large")
+	}
+	if header.MetaCount < 0 {
+		return nil, errors.New("readFCraftLevel: invalid metadata count")
+	}
+
+	lvl := &Level{
+		Width:  int16(header.Width),
+		Depth:  int16(header.Depth),
+		Height: int16(header.Height),
+		Spawn:  header.Spawn,
+		UUID:   header.GUID[:],
+	}
+	if err := lvl.checkDimensions(); err != nil {
+		return nil, err
+	}
+	if header.DateCreated > 0 {
+		lvl.CreateTime = time.Unix(int64(header.DateCreated), 0)
+	}
+
+	zr := flate.NewReader(r)
+	defer zr.Close()
+
+	for i := int32(0); i < header.MetaCount; i++ {
+		var entry [3]string
+		for j := range entry {
+			s, err := readFCraftString(zr)
+			if err != nil {
+				return nil, err
+			}
+			entry[j] = s
+		}
+
+		// Entries are (group, key, value)
+		switch strings.ToLower(entry[1]) {
+		case "name", "worldname":
+			lvl.Name = entry[2]
+		case "author", "creator", "createdby":
+			lvl.Creator = entry[2]
+		}
+	}
+
+	lvl.Blocks = make([]byte, lvl.Volume())
+	if _, err := io.ReadFull(zr, lvl.Blocks); err != nil {
+		return nil, err
+	}
+
+	return lvl, nil
+}
+
+func writeFCraftLevel(w io.Writer, lvl *Level) error {
+	meta := [][3]string{}
+	if lvl.Name != "" {
+		meta = append(meta, [3]string{"mcmuseum", "Name", lvl.Name})
+	}
+	if lvl.Creator != "" {
+		meta = append(meta, [3]string{"mcmuseum", "Author", lvl.Creator})
+	}
+
+	// The layer index needs the compressed size, so compress first
+	compressed := new(bytes.Buffer)
+	zw, err := flate.NewWriter(compressed, flate.DefaultCompression)
+	if err != nil {
+		return err
+	}
+	for _, entry := range meta {
+		for _, s := range entry {
+			if err := writeFCraftString(zw, s); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := zw.Write(lvl.Blocks); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	uuid, err := lvl.uuid()
+	if err != nil {
+		return err
+	}
+
+	header := struct {
+		Magic        uint32
+		Revision     byte
+		Width        uint16
+		Depth        uint16
+		Height       uint16
+		Spawn        Spawnpoint
+		DateModified uint32
+		DateCreated  uint32
+		GUID         [16]byte
+		LayerCount   byte
+		Layer        struct {
+			Type           byte
+			Offset         int64
+			CompressedLen  int32
+			GeneralPurpose int32
+			ElementSize    int32
+			ElementCount   int32
+		}
+		MetaCount int32
+	}{
+		Magic:        fcmMagic,
+		Revision:     fcmRevision,
+		Width:        uint16(lvl.Width),
+		Depth:        uint16(lvl.Depth),
+		Height:       uint16(lvl.Height),
+		Spawn:        lvl.Spawn,
+		DateModified: uint32(time.Now().Unix()),
+		LayerCount:   1,
+		MetaCount:    int32(len(meta)),
+	}
+	if !lvl.CreateTime.IsZero() {
+		header.DateCreated = uint32(lvl.CreateTime.Unix())
+	}
+	copy(header.GUID[:], uuid)
+	header.Layer.Offset = int64(binary.Size(header))
+	header.Layer.CompressedLen = int32(compressed.Len())
+	header.Layer.ElementSize = 1
+	header.Layer.ElementCount = int32(len(lvl.Blocks))
+
+	if err := binary.Write(w, binary.LittleEndian, header); err != nil {
+		return err
+	}
+
+	_, err = compressed.WriteTo(w)
+	return err
+}
+
+func writeFCraftString(w io.Writer, s string) error {
+	if len(s) > 0xffff {
+		return errors.New("writeFCraftLevel: string too long")
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint16(len(s))); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, s)
+	return err
+}
+
+func readFCraftString(r io.Reader) (string, error) {
+	var length uint16
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+
+	return string(buf), nil
+}
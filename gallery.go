@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"image/png"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Gallery is a small web front end for the museum: a list of levels, a page
+// for each of them and who is currently visiting what.
+type Gallery struct {
+	server *Server
+	museum *Museum
+	mux    *http.ServeMux
+
+	// Rendered PNGs, by level file and view
+	maps *mapCache
+	// Limits how many levels are read and rendered at once, since an index
+	// page asks for a thumbnail of every level
+	renders chan struct{}
+
+	// Shown as the play link if set, e.g. the URL returned by the heartbeat
+	PlayURL string
+	// Used to build a connection address when there is no PlayURL
+	GamePort int
+}
+
+const (
+	galleryMapCacheSize = 16 << 20
+	galleryMaxRenders   = 2
+)
+
+// mapCache keeps recently requested renders.  Everything is dropped when the
+// museum's levels are reloaded, since the files may have changed.
+type mapCache struct {
+	mutex      sync.Mutex
+	generation int
+	lru        *lruCache
+}
+
+func newMapCache(maxBytes int) *mapCache {
+	return &mapCache{lru: newLRUCache(maxBytes)}
+}
+
+func (c *mapCache) get(key string, generation int) ([]byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if generation > c.generation {
+		c.lru.purge()
+		c.generation = generation
+	}
+	if generation != c.generation {
+		return nil, false
+	}
+
+	data, ok := c.lru.get(key)
+	if !ok {
+		return nil, false
+	}
+
+	return data.([]byte), true
+}
+
+// add caches a render, unless the levels were reloaded while it was being
+// made.
+func (c *mapCache) add(key string, generation int, data []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	c.lru.add(key, data, len(data))
+}
+
+type galleryLevel struct {
+	LevelDescriptor
+	Players []string
+}
+
+func (l galleryLevel) Dimensions() string {
+	if l.Width == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("%d x %d x %d", l.Width, l.Depth, l.Height)
+}
+
+type galleryPage struct {
+	Museum  *Museum
+	PlayURL string
+	Address string
+	Players []ClientInfo
+	Levels  []galleryLevel
+	Level   galleryLevel
+}
+
+func NewGallery(server *Server) *Gallery {
+	g := &Gallery{
+		server:  server,
+		museum:  server.museum,
+		mux:     http.NewServeMux(),
+		maps:    newMapCache(galleryMapCacheSize),
+		renders: make(chan struct{}, galleryMaxRenders),
+	}
+	g.mux.HandleFunc("/", g.serveIndex)
+	g.mux.HandleFunc("/level/", g.serveLevel)
+	g.mux.HandleFunc("/map/", g.serveMap)
+
+	return g
+}
+
+func (g *Gallery) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mux.ServeHTTP(w, r)
+}
+
+func (g *Gallery) page(r *http.Request) *galleryPage {
+	page := &galleryPage{
+		Museum:  g.museum,
+		PlayURL: g.PlayURL,
+		Players: []ClientInfo{},
+	}
+
+	if page.PlayURL == "" {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		page.Address = net.JoinHostPort(host, strconv.Itoa(g.GamePort))
+	}
+
+	for _, c := range g.server.Clients() {
+		page.Players = append(page.Players, c.Info())
+	}
+
+	return page
+}
+
+// level finds who is visiting a level.  Levels are never loaded here; their
+// size is filled in by the museum in the background.
+func (g *Gallery) level(page *galleryPage, level LevelDescriptor) galleryLevel {
+	players := []string{}
+	for _, p := range page.Players {
+		if p.Level == level.Name {
+			players = append(players, p.Name)
+		}
+	}
+
+	return galleryLevel{level, players}
+}
+
+func (g *Gallery) serveIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	page := g.page(r)
+	for _, level := range g.museum.Levels() {
+		page.Levels = append(page.Levels, g.level(page, level))
+	}
+
+	g.render(w, "index", page)
+}
+
+func (g *Gallery) serveLevel(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/level/")
+	level, err := g.museum.GetLevel(name)
+	if err != nil || level.Hidden {
+		http.NotFound(w, r)
+		return
+	}
+
+	page := g.page(r)
+	page.Level = g.level(page, level)
+
+	g.render(w, "level", page)
+}
+
+// serveMap sends a picture of a level: /map/<name>.png for a top-down map,
+// or /map/<name>.png?view=iso for an isometric view.
+func (g *Gallery) serveMap(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/map/")
+	if !strings.HasSuffix(name, ".png") {
+		http.NotFound(w, r)
+		return
+	}
+	level, err := g.museum.GetLevel(strings.TrimSuffix(name, ".png"))
+	if err != nil || level.Hidden {
+		http.NotFound(w, r)
+		return
+	}
+
+	view := r.URL.Query().Get("view")
+	if view == "" {
+		view = RenderTopDown
+	}
+	if view != RenderTopDown && view != RenderIsometric {
+		http.Error(w, "Unknown view", http.StatusBadRequest)
+		return
+	}
+
+	key := level.Format + ":" + level.Path + ":" + view
+	generation := g.museum.Generation()
+	data, ok := g.maps.get(key, generation)
+
+	if !ok {
+		g.renders <- struct{}{}
+		data, err = renderMap(level, view)
+		<-g.renders
+		if err != nil {
+			log.Printf("[ERROR] Gallery failed to render %s: %s", level.Name, err.Error())
+			http.Error(w, "Level could not be rendered", http.StatusInternalServerError)
+			return
+		}
+
+		g.maps.add(key, generation, data)
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "max-age=3600")
+	w.Write(data)
+}
+
+// renderMap reads a level straight from its file, rather than through the
+// museum's cache, so that browsing the gallery doesn't evict levels players
+// are on.
+func renderMap(level LevelDescriptor, view string) ([]byte, error) {
+	lvl, err := ReadLevelFormat(level.Path, level.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := RenderLevel(lvl, view)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, img); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (g *Gallery) render(w http.ResponseWriter, name string, page *galleryPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := galleryTemplates.ExecuteTemplate(w, name, page); err != nil {
+		log.Printf("[ERROR] Gallery failed to render %s: %s", name, err.Error())
+	}
+}
+
+var galleryTemplates = template.Must(template.New("gallery").Parse(`
+{{define "header"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Museum.Name}}</title>
+<style>
+body { font-family: sans-serif; max-width: 60em; margin: 1em auto; padding: 0 1em; background: #222; color: #ddd; }
+a { color: #fc5; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 0.3em 0.6em; border-bottom: 1px solid #444; vertical-align: top; }
+.play { display: inline-block; padding: 0.5em 1em; background: #fc5; color: #222; text-decoration: none; font-weight: bold; }
+.tags { color: #999; }
+.thumb { width: 64px; image-rendering: pixelated; }
+.map { max-width: 100%; image-rendering: pixelated; }
+</style>
+</head>
+<body>
+<h1><a href="/">{{.Museum.Name}}</a></h1>
+{{with .Museum.MOTD}}<p>{{.}}</p>{{end}}
+<p>
+{{if .PlayURL}}<a class="play" href="{{.PlayURL}}">Play</a>
+{{else}}Connect with a Minecraft Classic client to <strong>{{.Address}}</strong>
+{{end}}
+</p>
+{{end}}
+
+{{define "footer"}}
+</body>
+</html>
+{{end}}
+
+{{define "index"}}{{template "header" .}}
+<h2>Online now ({{len .Players}})</h2>
+{{if .Players}}
+<ul>
+{{range .Players}}<li>{{.Name}}{{with .Level}} is visiting <a href="/level/{{.}}">{{.}}</a>{{end}}</li>
+{{end}}
+</ul>
+{{else}}<p>Nobody is visiting right now.</p>
+{{end}}
+<h2>Levels</h2>
+<table>
+<tr><th></th><th>Name</th><th>Date</th><th>Size</th><th>Description</th></tr>
+{{range .Levels}}<tr>
+<td><a href="/level/{{.Name}}"><img class="thumb" src="/map/{{.Name}}.png" alt=""></a></td>
+<td><a href="/level/{{.Name}}">{{.Name}}</a>{{if .Players}} ({{len .Players}} visiting){{end}}</td>
+<td>{{.Date}}</td>
+<td>{{.Dimensions}}</td>
+<td>{{.Description}}{{if .Tags}} <span class="tags">{{range $i, $t := .Tags}}{{if $i}}, {{end}}{{$t}}{{end}}</span>{{end}}</td>
+</tr>
+{{end}}
+</table>
+{{template "footer" .}}{{end}}
+
+{{define "level"}}{{template "header" .}}
+{{with .Level}}
+<h2>{{.Name}}</h2>
+{{with .Description}}<p>{{.}}</p>{{end}}
+<table>
+{{with .WorldName}}<tr><th>World name</th><td>{{.}}</td></tr>{{end}}
+<tr><th>Date</th><td>{{.Date}}</td></tr>
+{{with .Author}}<tr><th>Author</th><td>{{.}}</td></tr>{{end}}
+{{with .Server}}<tr><th>Server</th><td>{{.}}</td></tr>{{end}}
+{{with .Dimensions}}<tr><th>Size</th><td>{{.}}</td></tr>{{end}}
+{{with .OriginalFilename}}<tr><th>Original file</th><td>{{.}}</td></tr>{{end}}
+{{if .Tags}}<tr><th>Tags</th><td>{{range $i, $t := .Tags}}{{if $i}}, {{end}}{{$t}}{{end}}</td></tr>{{end}}
+<tr><th>Visiting now</th><td>{{if .Players}}{{range $i, $p := .Players}}{{if $i}}, {{end}}{{$p}}{{end}}{{else}}Nobody{{end}}</td></tr>
+</table>
+<p><img class="map" src="/map/{{.Name}}.png?view=iso" alt="Isometric view of {{.Name}}"></p>
+<p><img class="map" src="/map/{{.Name}}.png" alt="Map of {{.Name}}"></p>
+<p>Type <code>/goto {{.Name}}</code> in game to visit this level.</p>
+{{end}}
+{{template "footer" .}}{{end}}
+`))
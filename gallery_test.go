@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMapCacheClearedOnReload(t *testing.T) {
+	c := newMapCache(10)
+	c.add("a", 0, []byte("old"))
+
+	if _, ok := c.get("a", 1); ok {
+		t.Fatal("render from before the reload was kept")
+	}
+
+	// A render started before the reload mustn't be cached after it
+	c.add("a", 0, []byte("old"))
+	if _, ok := c.get("a", 1); ok {
+		t.Fatal("render from before the reload was added")
+	}
+
+	c.add("a", 1, []byte("new"))
+	if data, ok := c.get("a", 1); !ok || string(data) != "new" {
+		t.Fatalf("got %q, %v, want new render", data, ok)
+	}
+}
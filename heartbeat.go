@@ -59,21 +59,31 @@ func (hb *Heartbeat) Send() (string, error) {
 	return string(playURL), nil
 }
 
-func startHeartbeats(hb *Heartbeat, connects <-chan bool, disconnects <-chan bool) {
+// startHeartbeats sends a heartbeat every minute until stop is closed, and
+// then sends a final one to take the server off the public list.
+func startHeartbeats(hb *Heartbeat, server *Server, stop <-chan struct{}) {
 	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
 
 	for {
 		select {
-		case <-connects:
-			hb.NumConnected++
-		case <-disconnects:
-			hb.NumConnected--
 		case <-ticker.C:
+			hb.NumConnected = server.NumClients()
+
 			log.Println("Sending heartbeat")
 			_, err := hb.Send()
 			if err != nil {
 				log.Printf("Heartbeat failed: %s", err.Error())
 			}
+		case <-stop:
+			hb.NumConnected = 0
+			hb.Public = false
+
+			log.Println("Sending final heartbeat")
+			if _, err := hb.Send(); err != nil {
+				log.Printf("Heartbeat failed: %s", err.Error())
+			}
+			return
 		}
 	}
 }
@@ -0,0 +1,596 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Minimal decoder for the Java Object Serialization Stream Protocol, which is
+// what Minecraft Classic uses to save levels.  Only deserialization is
+// supported, and class data is kept generic (field name -> value) rather than
+// being mapped onto Go types.
+
+const (
+	javaStreamMagic   = 0xaced
+	javaStreamVersion = 5
+	javaBaseHandle    = 0x7e0000
+
+	// Lengths read from the stream are untrusted, so large byte arrays are
+	// read in chunks and other arrays are limited in size
+	javaReadChunk      = 1 << 20
+	javaMaxArrayLength = 1 << 20
+)
+
+const (
+	tcNull           = 0x70
+	tcReference      = 0x71
+	tcClassDesc      = 0x72
+	tcObject         = 0x73
+	tcString         = 0x74
+	tcArray          = 0x75
+	tcClass          = 0x76
+	tcBlockData      = 0x77
+	tcEndBlockData   = 0x78
+	tcReset          = 0x79
+	tcBlockDataLong  = 0x7a
+	tcException      = 0x7b
+	tcLongString     = 0x7c
+	tcProxyClassDesc = 0x7d
+	tcEnum           = 0x7e
+)
+
+const (
+	scWriteMethod    = 0x01
+	scSerializable   = 0x02
+	scExternalizable = 0x04
+	scBlockData      = 0x08
+)
+
+type javaField struct {
+	typecode  byte
+	name      string
+	className string
+}
+
+type javaClassDesc struct {
+	name   string
+	flags  byte
+	fields []javaField
+	super  *javaClassDesc
+}
+
+type javaObject struct {
+	class  *javaClassDesc
+	fields map[string]interface{}
+}
+
+type javaArray struct {
+	class  *javaClassDesc
+	values interface{}
+}
+
+type javaEnum struct {
+	class    *javaClassDesc
+	constant string
+}
+
+type javaBlockData []byte
+
+type javaDecoder struct {
+	r       io.Reader
+	handles []interface{}
+}
+
+func readJavaObject(r io.Reader) (interface{}, error) {
+	dec := &javaDecoder{r: r}
+
+	var header struct {
+		Magic   uint16
+		Version uint16
+	}
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, err
+	}
+	if header.Magic != javaStreamMagic {
+		return nil, errors.New("not a Java serialization stream")
+	}
+	if header.Version != javaStreamVersion {
+		return nil, fmt.Errorf("unsupported Java serialization version %d", header.Version)
+	}
+
+	return dec.readContent()
+}
+
+func (dec *javaDecoder) read(data interface{}) error {
+	return binary.Read(dec.r, binary.BigEndian, data)
+}
+
+func (dec *javaDecoder) readByte() (byte, error) {
+	var b byte
+	err := dec.read(&b)
+	return b, err
+}
+
+// readBytes reads length bytes a chunk at a time, so that a corrupt length
+// fails once the stream runs out instead of allocating it all up front.
+func (dec *javaDecoder) readBytes(length int) ([]byte, error) {
+	buf := []byte{}
+	for len(buf) < length {
+		n := length - len(buf)
+		if n > javaReadChunk {
+			n = javaReadChunk
+		}
+
+		start := len(buf)
+		buf = append(buf, make([]byte, n)...)
+		if _, err := io.ReadFull(dec.r, buf[start:]); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf, nil
+}
+
+func (dec *javaDecoder) readUTF() (string, error) {
+	var length uint16
+	if err := dec.read(&length); err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(dec.r, buf); err != nil {
+		return "", err
+	}
+
+	return string(buf), nil
+}
+
+func (dec *javaDecoder) readLongUTF() (string, error) {
+	var length int64
+	if err := dec.read(&length); err != nil {
+		return "", err
+	}
+	if length < 0 || length > math.MaxInt32 {
+		return "", errors.New("invalid long string length")
+	}
+
+	buf, err := dec.readBytes(int(length))
+	if err != nil {
+		return "", err
+	}
+
+	return string(buf), nil
+}
+
+func (dec *javaDecoder) newHandle(obj interface{}) int {
+	dec.handles = append(dec.handles, obj)
+	return len(dec.handles) - 1
+}
+
+func (dec *javaDecoder) readReference() (interface{}, error) {
+	var handle int32
+	if err := dec.read(&handle); err != nil {
+		return nil, err
+	}
+
+	index := int(handle) - javaBaseHandle
+	if index < 0 || index >= len(dec.handles) {
+		return nil, fmt.Errorf("invalid object handle 0x%x", handle)
+	}
+
+	return dec.handles[index], nil
+}
+
+func (dec *javaDecoder) readContent() (interface{}, error) {
+	tc, err := dec.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	return dec.readContentTC(tc)
+}
+
+func (dec *javaDecoder) readContentTC(tc byte) (interface{}, error) {
+	switch tc {
+	case tcNull:
+		return nil, nil
+	case tcReference:
+		return dec.readReference()
+	case tcClassDesc, tcProxyClassDesc:
+		return dec.readClassDescTC(tc)
+	case tcObject:
+		return dec.readNewObject()
+	case tcString:
+		s, err := dec.readUTF()
+		if err != nil {
+			return nil, err
+		}
+		dec.newHandle(s)
+		return s, nil
+	case tcLongString:
+		s, err := dec.readLongUTF()
+		if err != nil {
+			return nil, err
+		}
+		dec.newHandle(s)
+		return s, nil
+	case tcArray:
+		return dec.readNewArray()
+	case tcClass:
+		class, err := dec.readClassDesc()
+		if err != nil {
+			return nil, err
+		}
+		dec.newHandle(class)
+		return class, nil
+	case tcEnum:
+		return dec.readNewEnum()
+	case tcBlockData:
+		length, err := dec.readByte()
+		if err != nil {
+			return nil, err
+		}
+		return dec.readBlockData(int(length))
+	case tcBlockDataLong:
+		var length int32
+		if err := dec.read(&length); err != nil {
+			return nil, err
+		}
+		if length < 0 {
+			return nil, errors.New("invalid block data length")
+		}
+		return dec.readBlockData(int(length))
+	case tcReset:
+		dec.handles = nil
+		return dec.readContent()
+	case tcException:
+		return nil, errors.New("stream contains a serialized exception")
+	default:
+		return nil, fmt.Errorf("unexpected type code 0x%02x", tc)
+	}
+}
+
+func (dec *javaDecoder) readBlockData(length int) (javaBlockData, error) {
+	buf, err := dec.readBytes(length)
+	if err != nil {
+		return nil, err
+	}
+
+	return javaBlockData(buf), nil
+}
+
+func (dec *javaDecoder) readClassDesc() (*javaClassDesc, error) {
+	tc, err := dec.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tc {
+	case tcNull:
+		return nil, nil
+	case tcReference:
+		ref, err := dec.readReference()
+		if err != nil {
+			return nil, err
+		}
+		class, ok := ref.(*javaClassDesc)
+		if !ok {
+			return nil, errors.New("reference is not a class descriptor")
+		}
+		return class, nil
+	case tcClassDesc, tcProxyClassDesc:
+		return dec.readClassDescTC(tc)
+	default:
+		return nil, fmt.Errorf("expected class descriptor, got type code 0x%02x", tc)
+	}
+}
+
+func (dec *javaDecoder) readClassDescTC(tc byte) (*javaClassDesc, error) {
+	class := &javaClassDesc{}
+
+	if tc == tcProxyClassDesc {
+		dec.newHandle(class)
+
+		var count int32
+		if err := dec.read(&count); err != nil {
+			return nil, err
+		}
+		for i := int32(0); i < count; i++ {
+			if _, err := dec.readUTF(); err != nil {
+				return nil, err
+			}
+		}
+		class.name = "<proxy>"
+		class.flags = scSerializable
+	} else {
+		name, err := dec.readUTF()
+		if err != nil {
+			return nil, err
+		}
+		class.name = name
+
+		var serialVersionUID int64
+		if err := dec.read(&serialVersionUID); err != nil {
+			return nil, err
+		}
+		dec.newHandle(class)
+
+		if class.flags, err = dec.readByte(); err != nil {
+			return nil, err
+		}
+
+		var count int16
+		if err := dec.read(&count); err != nil {
+			return nil, err
+		}
+		for i := int16(0); i < count; i++ {
+			field, err := dec.readFieldDesc()
+			if err != nil {
+				return nil, err
+			}
+			class.fields = append(class.fields, field)
+		}
+	}
+
+	if err := dec.skipAnnotation(); err != nil {
+		return nil, err
+	}
+
+	super, err := dec.readClassDesc()
+	if err != nil {
+		return nil, err
+	}
+	class.super = super
+
+	return class, nil
+}
+
+func (dec *javaDecoder) readFieldDesc() (javaField, error) {
+	field := javaField{}
+
+	typecode, err := dec.readByte()
+	if err != nil {
+		return field, err
+	}
+	field.typecode = typecode
+
+	if field.name, err = dec.readUTF(); err != nil {
+		return field, err
+	}
+
+	if typecode == 'L' || typecode == '[' {
+		className, err := dec.readContent()
+		if err != nil {
+			return field, err
+		}
+		s, ok := className.(string)
+		if !ok {
+			return field, errors.New("field class name is not a string")
+		}
+		field.className = s
+	}
+
+	return field, nil
+}
+
+// skipAnnotation discards class and object annotations (anything written by
+// custom writeObject methods) up to the terminating TC_ENDBLOCKDATA.
+func (dec *javaDecoder) skipAnnotation() error {
+	for {
+		tc, err := dec.readByte()
+		if err != nil {
+			return err
+		}
+		if tc == tcEndBlockData {
+			return nil
+		}
+		if _, err := dec.readContentTC(tc); err != nil {
+			return err
+		}
+	}
+}
+
+func (dec *javaDecoder) readNewObject() (*javaObject, error) {
+	class, err := dec.readClassDesc()
+	if err != nil {
+		return nil, err
+	}
+	if class == nil {
+		return nil, errors.New("object has null class descriptor")
+	}
+
+	obj := &javaObject{
+		class:  class,
+		fields: make(map[string]interface{}),
+	}
+	dec.newHandle(obj)
+
+	// Class data is written starting from the topmost serializable superclass
+	hierarchy := []*javaClassDesc{}
+	for c := class; c != nil; c = c.super {
+		hierarchy = append([]*javaClassDesc{c}, hierarchy...)
+	}
+
+	for _, c := range hierarchy {
+		if c.flags&scExternalizable != 0 {
+			if c.flags&scBlockData == 0 {
+				return nil, fmt.Errorf("cannot decode externalizable class %s", c.name)
+			}
+			if err := dec.skipAnnotation(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		for _, field := range c.fields {
+			value, err := dec.readFieldValue(field.typecode)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %s", c.name, field.name, err.Error())
+			}
+			obj.fields[field.name] = value
+		}
+
+		if c.flags&scWriteMethod != 0 {
+			if err := dec.skipAnnotation(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return obj, nil
+}
+
+func (dec *javaDecoder) readFieldValue(typecode byte) (interface{}, error) {
+	var err error
+
+	switch typecode {
+	case 'B':
+		var v int8
+		err = dec.read(&v)
+		return v, err
+	case 'C':
+		var v uint16
+		err = dec.read(&v)
+		return v, err
+	case 'D':
+		var v float64
+		err = dec.read(&v)
+		return v, err
+	case 'F':
+		var v float32
+		err = dec.read(&v)
+		return v, err
+	case 'I':
+		var v int32
+		err = dec.read(&v)
+		return v, err
+	case 'J':
+		var v int64
+		err = dec.read(&v)
+		return v, err
+	case 'S':
+		var v int16
+		err = dec.read(&v)
+		return v, err
+	case 'Z':
+		var v bool
+		err = dec.read(&v)
+		return v, err
+	case 'L', '[':
+		return dec.readContent()
+	default:
+		return nil, fmt.Errorf("invalid field type code '%c'", typecode)
+	}
+}
+
+func (dec *javaDecoder) readNewArray() (*javaArray, error) {
+	class, err := dec.readClassDesc()
+	if err != nil {
+		return nil, err
+	}
+	if class == nil || len(class.name) < 2 || class.name[0] != '[' {
+		return nil, errors.New("invalid array class descriptor")
+	}
+
+	arr := &javaArray{class: class}
+	dec.newHandle(arr)
+
+	var length int32
+	if err := dec.read(&length); err != nil {
+		return nil, err
+	}
+	if length < 0 {
+		return nil, errors.New("negative array length")
+	}
+	// Block arrays are read as the data arrives; anything else in a level is
+	// small
+	if class.name[1] != 'B' && length > javaMaxArrayLength {
+		return nil, fmt.Errorf("array of %d elements is too long", length)
+	}
+
+	switch class.name[1] {
+	case 'B':
+		arr.values, err = dec.readBytes(int(length))
+	case 'C':
+		values := make([]uint16, length)
+		err = dec.read(values)
+		arr.values = values
+	case 'D':
+		values := make([]float64, length)
+		err = dec.read(values)
+		arr.values = values
+	case 'F':
+		values := make([]float32, length)
+		err = dec.read(values)
+		arr.values = values
+	case 'I':
+		values := make([]int32, length)
+		err = dec.read(values)
+		arr.values = values
+	case 'J':
+		values := make([]int64, length)
+		err = dec.read(values)
+		arr.values = values
+	case 'S':
+		values := make([]int16, length)
+		err = dec.read(values)
+		arr.values = values
+	case 'Z':
+		values := make([]bool, length)
+		err = dec.read(values)
+		arr.values = values
+	default:
+		values := make([]interface{}, length)
+		for i := range values {
+			if values[i], err = dec.readContent(); err != nil {
+				break
+			}
+		}
+		arr.values = values
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return arr, nil
+}
+
+func (dec *javaDecoder) readNewEnum() (*javaEnum, error) {
+	class, err := dec.readClassDesc()
+	if err != nil {
+		return nil, err
+	}
+
+	enum := &javaEnum{class: class}
+	dec.newHandle(enum)
+
+	constant, err := dec.readContent()
+	if err != nil {
+		return nil, err
+	}
+	s, ok := constant.(string)
+	if !ok {
+		return nil, errors.New("enum constant name is not a string")
+	}
+	enum.constant = s
+
+	return enum, nil
+}
+
+func (obj *javaObject) intField(name string) (int32, error) {
+	v, ok := obj.fields[name].(int32)
+	if !ok {
+		return 0, fmt.Errorf("%s has no int field %s", obj.class.name, name)
+	}
+
+	return v, nil
+}
+
+func (obj *javaObject) stringField(name string) string {
+	s, _ := obj.fields[name].(string)
+	return s
+}
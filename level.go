@@ -1,11 +1,11 @@
 package main
 
 import (
-	"compress/gzip"
+	"crypto/rand"
+	"encoding/binary"
 	"errors"
 	"io"
-	"log"
-	"os"
+	"time"
 )
 
 type Spawnpoint struct {
@@ -22,55 +22,143 @@ type Level struct {
 	Depth  int16
 	Height int16
 	Spawn  Spawnpoint
+
+	// Optional metadata; not every format stores these
+	Name       string
+	Creator    string
+	CreateTime time.Time
+	UUID       []byte
 }
 
-func ReadLevel(filename string) (*Level, error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return nil, err
+func (lvl *Level) Volume() int {
+	return int(lvl.Width) * int(lvl.Depth) * int(lvl.Height)
+}
+
+func (lvl *Level) checkDimensions() error {
+	if lvl.Width <= 0 || lvl.Depth <= 0 || lvl.Height <= 0 {
+		return errors.New("invalid level dimensions")
 	}
-	defer file.Close()
 
-	gzin, err := gzip.NewReader(file)
-	if err != nil {
+	return nil
+}
+
+// uuid returns the level's UUID, or a new random one if it doesn't have one.
+func (lvl *Level) uuid() ([]byte, error) {
+	if len(lvl.UUID) == 16 {
+		return lvl.UUID, nil
+	}
+
+	uuid := make([]byte, 16)
+	if _, err := rand.Read(uuid); err != nil {
 		return nil, err
 	}
-	defer gzin.Close()
+	// Random (version 4) UUID
+	uuid[6] = uuid[6]&0x0f | 0x40
+	uuid[8] = uuid[8]&0x3f | 0x80
+
+	return uuid, nil
+}
+
+// defaultSpawn picks a spawn point on top of the highest block in the middle
+// of the level, for formats that don't store one.
+func (lvl *Level) defaultSpawn() Spawnpoint {
+	x, z := int(lvl.Width)/2, int(lvl.Height)/2
+	y := int(lvl.Depth) - 1
+	for y > 0 {
+		if lvl.Blocks[(y*int(lvl.Height)+z)*int(lvl.Width)+x] != 0 {
+			break
+		}
+		y--
+	}
+
+	return Spawnpoint{
+		X: int16(x<<5 + 16),
+		Y: int16((y + 2) << 5),
+		Z: int16(z<<5 + 16),
+	}
+}
+
+// The simplified dump format (originally written by a Java tool, now by
+// "mcmuseum convert") is gzipped: the big endian width, depth and height, the
+// spawn point in player units, then the block array.  It has no magic number,
+// so it is detected by checking that the header looks sane.
+const dumpMaxDimension = 2048
+
+func init() {
+	RegisterLevelFormat(&LevelFormat{
+		Name:       LevelFormatDump,
+		Extensions: []string{".dump"},
+		Gzipped:    true,
+		Detect:     detectDumpLevel,
+		Loader:     LevelLoaderFunc(readDumpLevel),
+		Writer:     LevelWriterFunc(writeDumpLevel),
+	})
+}
+
+func detectDumpLevel(header []byte) bool {
+	if len(header) < 12 {
+		return false
+	}
+
+	var fields [6]int
+	for i := range fields {
+		fields[i] = int(int16(header[2*i])<<8 | int16(header[2*i+1]))
+	}
+
+	for i := 0; i < 3; i++ {
+		if fields[i] <= 0 || fields[i] > dumpMaxDimension {
+			return false
+		}
+		if fields[i+3] < 0 || fields[i+3] > (fields[i]+4)<<5 {
+			return false
+		}
+	}
+
+	return true
+}
 
-	width, err := readInt16(gzin)
+func readDumpLevel(r io.Reader) (*Level, error) {
+	width, err := readInt16(r)
 	if err != nil {
 		return nil, err
 	}
-	depth, err := readInt16(gzin)
+	depth, err := readInt16(r)
 	if err != nil {
 		return nil, err
 	}
-	height, err := readInt16(gzin)
+	height, err := readInt16(r)
 	if err != nil {
 		return nil, err
 	}
 
 	spawn := Spawnpoint{}
-	spawn.X, err = readInt16(gzin)
+	spawn.X, err = readInt16(r)
 	if err != nil {
 		return nil, err
 	}
-	spawn.Y, err = readInt16(gzin)
+	spawn.Y, err = readInt16(r)
 	if err != nil {
 		return nil, err
 	}
-	spawn.Z, err = readInt16(gzin)
+	spawn.Z, err = readInt16(r)
 	if err != nil {
 		return nil, err
 	}
 
+	if width <= 0 || depth <= 0 || height <= 0 {
+		return nil, errors.New("ReadLevel: invalid level dimensions")
+	}
+
 	blocks := make([]byte, int(width)*int(depth)*int(height))
 	i := 0
 	for i < len(blocks) {
-		n, err := gzin.Read(blocks[i:])
+		n, err := r.Read(blocks[i:])
 		if err != nil && err != io.EOF {
 			return nil, err
 		}
+		if n == 0 && err == io.EOF {
+			break
+		}
 
 		i += n
 	}
@@ -79,13 +167,6 @@ func ReadLevel(filename string) (*Level, error) {
 		return nil, errors.New("ReadLevel: file too short")
 	}
 
-	log.Printf(
-		"Loaded level from %s (size = %d x %d x %d)",
-		filename,
-		width,
-		depth,
-		height)
-
 	return &Level{
 		Blocks: blocks,
 		Width:  width,
@@ -94,3 +175,16 @@ func ReadLevel(filename string) (*Level, error) {
 		Spawn:  spawn,
 	}, nil
 }
+
+func writeDumpLevel(w io.Writer, lvl *Level) error {
+	header := []int16{
+		lvl.Width, lvl.Depth, lvl.Height,
+		lvl.Spawn.X, lvl.Spawn.Y, lvl.Spawn.Z,
+	}
+	if err := binary.Write(w, binary.BigEndian, header); err != nil {
+		return err
+	}
+
+	_, err := w.Write(lvl.Blocks)
+	return err
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"sync"
+)
+
+// CachedLevel is a parsed level along with the block data exactly as it is
+// sent to clients, so it only has to be compressed once.  Each payload is
+// compressed the first time it is needed, since most levels are only ever
+// sent one way.
+type CachedLevel struct {
+	Level *Level
+
+	// Gzipped block array, prefixed with its length
+	payload lazyPayload
+	// Raw DEFLATE block array, for clients with the FastMap extension
+	fastMapPayload lazyPayload
+
+	// Told how big the payloads are once they have been built
+	cache *LevelCache
+	key   string
+}
+
+type lazyPayload struct {
+	once sync.Once
+	data []byte
+	err  error
+}
+
+// Compressors are large, so they are shared by every level load
+var (
+	gzipWriters = sync.Pool{
+		New: func() interface{} { return gzip.NewWriter(nil) },
+	}
+	flateWriters = sync.Pool{
+		New: func() interface{} {
+			zout, _ := flate.NewWriter(nil, flate.DefaultCompression)
+			return zout
+		},
+	}
+)
+
+func NewCachedLevel(lvl *Level) *CachedLevel {
+	return &CachedLevel{Level: lvl}
+}
+
+func (cl *CachedLevel) Payload() ([]byte, error) {
+	return cl.build(&cl.payload, gzipBlocks)
+}
+
+func (cl *CachedLevel) FastMapPayload() ([]byte, error) {
+	return cl.build(&cl.fastMapPayload, deflateBlocks)
+}
+
+func (cl *CachedLevel) build(p *lazyPayload, compress func([]byte) ([]byte, error)) ([]byte, error) {
+	p.once.Do(func() {
+		p.data, p.err = compress(cl.Level.Blocks)
+		if p.err == nil && cl.cache != nil {
+			cl.cache.grow(cl.key, cl, len(p.data))
+		}
+	})
+
+	return p.data, p.err
+}
+
+func gzipBlocks(blocks []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	gzout := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(gzout)
+	gzout.Reset(buf)
+
+	if err := writeInt32(gzout, len(blocks)); err != nil {
+		return nil, err
+	}
+	if _, err := gzout.Write(blocks); err != nil {
+		return nil, err
+	}
+	if err := gzout.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func deflateBlocks(blocks []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	zout := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(zout)
+	zout.Reset(buf)
+
+	if _, err := zout.Write(blocks); err != nil {
+		return nil, err
+	}
+	if err := zout.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+type pendingLoad struct {
+	done  chan struct{}
+	level *CachedLevel
+	err   error
+}
+
+// LevelCache keeps recently visited levels in memory, evicting the least
+// recently used ones once the total size exceeds maxBytes.  A maxBytes of 0
+// disables caching.
+type LevelCache struct {
+	mutex   sync.Mutex
+	lru     *lruCache
+	pending map[string]*pendingLoad
+}
+
+func NewLevelCache(maxBytes int) *LevelCache {
+	return &LevelCache{
+		lru:     newLRUCache(maxBytes),
+		pending: make(map[string]*pendingLoad),
+	}
+}
+
+// Get returns the cached level for key, calling load to fill the cache on a
+// miss.  Concurrent misses for the same key share a single load.
+func (c *LevelCache) Get(key string, load func() (*CachedLevel, error)) (*CachedLevel, error) {
+	c.mutex.Lock()
+	if level, ok := c.lru.get(key); ok {
+		c.mutex.Unlock()
+		return level.(*CachedLevel), nil
+	}
+
+	if p, ok := c.pending[key]; ok {
+		c.mutex.Unlock()
+		<-p.done
+		return p.level, p.err
+	}
+
+	p := &pendingLoad{done: make(chan struct{})}
+	c.pending[key] = p
+	c.mutex.Unlock()
+
+	p.level, p.err = load()
+
+	c.mutex.Lock()
+	delete(c.pending, key)
+	if p.err == nil {
+		p.level.cache, p.level.key = c, key
+		c.lru.add(key, p.level, len(p.level.Level.Blocks))
+	}
+	c.mutex.Unlock()
+	close(p.done)
+
+	return p.level, p.err
+}
+
+// grow accounts for a payload built after level was cached.
+func (c *LevelCache) grow(key string, level *CachedLevel, size int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.lru.grow(key, level, size)
+}
+
+// Purge empties the cache.
+func (c *LevelCache) Purge() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.lru.purge()
+}
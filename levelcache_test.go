@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+)
+
+// benchmarkLevel returns a 256x64x256 level with some hills, so that it
+// compresses about as well as a real one.
+func benchmarkLevel() *Level {
+	lvl := &Level{Width: 256, Depth: 64, Height: 256}
+	lvl.Blocks = make([]byte, lvl.Volume())
+
+	for z := 0; z < int(lvl.Height); z++ {
+		for x := 0; x < int(lvl.Width); x++ {
+			ground := 28 + (x*7+z*13)%9
+			for y := 0; y <= ground; y++ {
+				block := byte(1)
+				if y == ground {
+					block = 2
+				} else if y > ground-4 {
+					block = 3
+				}
+				lvl.Blocks[(y*int(lvl.Height)+z)*int(lvl.Width)+x] = block
+			}
+		}
+	}
+
+	return lvl
+}
+
+func BenchmarkNewCachedLevel(b *testing.B) {
+	lvl := benchmarkLevel()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(lvl.Blocks)))
+	for i := 0; i < b.N; i++ {
+		cached := NewCachedLevel(lvl)
+		if _, err := cached.Payload(); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := cached.FastMapPayload(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestCachedLevelPayloadsBuiltLazily(t *testing.T) {
+	lvl := benchmarkLevel()
+	c := NewLevelCache(1 << 30)
+	cached, err := c.Get("test", func() (*CachedLevel, error) {
+		return NewCachedLevel(lvl), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.lru.size != len(lvl.Blocks) {
+		t.Fatalf("cache size %d before sending, want %d", c.lru.size, len(lvl.Blocks))
+	}
+
+	payload, err := cached.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.lru.size != len(lvl.Blocks)+len(payload) {
+		t.Fatalf("cache size %d, want %d", c.lru.size, len(lvl.Blocks)+len(payload))
+	}
+	if again, _ := cached.Payload(); &again[0] != &payload[0] {
+		t.Error("payload compressed twice")
+	}
+
+	gzin, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(gzin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 4+len(lvl.Blocks) || !bytes.Equal(data[4:], lvl.Blocks) {
+		t.Fatal("payload doesn't hold the level's blocks")
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	LevelFormatDump         = "dump"
+	LevelFormatDat          = "dat"
+	LevelFormatClassicWorld = "cw"
+	LevelFormatMCSharp      = "lvl"
+	LevelFormatFCraft       = "fcm"
+)
+
+// Number of bytes passed to LevelFormat.Detect
+const levelSignatureSize = 16
+
+// LevelLoader decodes a level from the raw contents of a level file.
+type LevelLoader interface {
+	LoadLevel(r io.Reader) (*Level, error)
+}
+
+type LevelLoaderFunc func(r io.Reader) (*Level, error)
+
+func (f LevelLoaderFunc) LoadLevel(r io.Reader) (*Level, error) {
+	return f(r)
+}
+
+// LevelWriter encodes a level in the format of a level file.
+type LevelWriter interface {
+	WriteLevel(w io.Writer, lvl *Level) error
+}
+
+type LevelWriterFunc func(w io.Writer, lvl *Level) error
+
+func (f LevelWriterFunc) WriteLevel(w io.Writer, lvl *Level) error {
+	return f(w, lvl)
+}
+
+type LevelFormat struct {
+	Name string
+	// File extensions (including the dot) usually used for this format
+	Extensions []string
+	// Gzipped formats are compressed in their entirety.  The gzip stream is
+	// decompressed before calling Detect or Loader.
+	Gzipped bool
+	// Detect reports whether a file starts with this format's signature.
+	// Formats that can't be recognized from their contents leave it nil,
+	// and are only selected by extension.
+	Detect func(header []byte) bool
+	// Set if older files in this format have no signature, so a file with
+	// a matching extension is accepted even if Detect fails.
+	OptionalSignature bool
+	Loader            LevelLoader
+	// Writer is nil for formats that can only be read.  Like Loader, it
+	// doesn't handle the gzip compression of Gzipped formats.
+	Writer LevelWriter
+}
+
+var levelFormats = []*LevelFormat{}
+
+// RegisterLevelFormat makes a level format available to ReadLevel and the
+// manifest.  It is meant to be called from init functions.
+func RegisterLevelFormat(format *LevelFormat) {
+	if GetLevelFormat(format.Name) != nil {
+		panic("level format " + format.Name + " registered twice")
+	}
+
+	levelFormats = append(levelFormats, format)
+}
+
+func GetLevelFormat(name string) *LevelFormat {
+	for _, format := range levelFormats {
+		if format.Name == name {
+			return format
+		}
+	}
+
+	return nil
+}
+
+func IsLevelFormat(name string) bool {
+	return GetLevelFormat(name) != nil
+}
+
+func (format *LevelFormat) hasExtension(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	for _, e := range format.Extensions {
+		if e == ext {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (format *LevelFormat) load(r io.Reader) (*Level, error) {
+	if !format.Gzipped {
+		return format.Loader.LoadLevel(r)
+	}
+
+	gzin, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gzin.Close()
+
+	return format.Loader.LoadLevel(bufio.NewReader(gzin))
+}
+
+func (format *LevelFormat) write(w io.Writer, lvl *Level) error {
+	if format.Writer == nil {
+		return fmt.Errorf("writing %s levels is not supported", format.Name)
+	}
+	if len(lvl.Blocks) != lvl.Volume() {
+		return errors.New("block array does not match level size")
+	}
+
+	if !format.Gzipped {
+		return format.Writer.WriteLevel(w, lvl)
+	}
+
+	gzout := gzip.NewWriter(w)
+	if err := format.Writer.WriteLevel(gzout, lvl); err != nil {
+		return err
+	}
+
+	return gzout.Close()
+}
+
+// ReadLevel loads a level, detecting its format.
+func ReadLevel(filename string) (*Level, error) {
+	return ReadLevelFormat(filename, "")
+}
+
+// ReadLevelFormat loads a level in the named format, or detects the format if
+// the name is empty.
+func ReadLevelFormat(filename, name string) (*Level, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var format *LevelFormat
+	if name == "" {
+		if format, err = DetectLevelFormat(filename, file); err != nil {
+			return nil, err
+		}
+		if _, err = file.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+	} else if format = GetLevelFormat(name); format == nil {
+		return nil, fmt.Errorf("%s: unknown level format %s", filename, name)
+	}
+
+	lvl, err := format.load(bufio.NewReader(file))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err.Error())
+	}
+
+	log.Printf(
+		"Loaded %s level from %s (size = %d x %d x %d)",
+		format.Name,
+		filename,
+		lvl.Width,
+		lvl.Depth,
+		lvl.Height)
+
+	return lvl, nil
+}
+
+// WriteLevelFormat saves a level in the named format, or the format matching
+// the filename's extension if the name is empty.
+func WriteLevelFormat(filename string, lvl *Level, name string) error {
+	var format *LevelFormat
+	if name == "" {
+		if format = LevelFormatForFilename(filename); format == nil {
+			return fmt.Errorf("%s: can't tell level format from extension", filename)
+		}
+	} else if format = GetLevelFormat(name); format == nil {
+		return fmt.Errorf("%s: unknown level format %s", filename, name)
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	buf := bufio.NewWriter(file)
+	if err := format.write(buf, lvl); err != nil {
+		return fmt.Errorf("%s: %s", filename, err.Error())
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// LevelFormatForFilename returns the format whose extension a file has, or
+// nil if there isn't exactly one.
+func LevelFormatForFilename(filename string) *LevelFormat {
+	var found *LevelFormat
+	for _, format := range levelFormats {
+		if format.hasExtension(filename) {
+			if found != nil {
+				return nil
+			}
+			found = format
+		}
+	}
+
+	return found
+}
+
+// DetectLevelFormat identifies the format of a level file.  The file
+// extension is trusted unless the file's signature rules it out; otherwise
+// the format is chosen by signature.
+func DetectLevelFormat(filename string, r io.Reader) (*LevelFormat, error) {
+	br := bufio.NewReader(r)
+	raw, err := br.Peek(levelSignatureSize)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%s: %s", filename, err.Error())
+	}
+	signature := fmt.Sprintf("% x", raw)
+
+	var decompressed []byte
+	if len(raw) >= 2 && raw[0] == 0x1f && raw[1] == 0x8b {
+		gzin, err := gzip.NewReader(br)
+		if err == nil {
+			decompressed, err = bufio.NewReader(gzin).Peek(levelSignatureSize)
+			gzin.Close()
+		}
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("%s: corrupt gzip stream: %s", filename, err.Error())
+		}
+		signature = fmt.Sprintf("gzip, % x", decompressed)
+	}
+
+	matches := []*LevelFormat{}
+	byExtension := []*LevelFormat{}
+	for _, format := range levelFormats {
+		header := raw
+		if format.Gzipped {
+			header = decompressed
+		}
+
+		matched := header != nil && format.Detect != nil && format.Detect(header)
+		if matched {
+			matches = append(matches, format)
+		}
+
+		if format.hasExtension(filename) {
+			if matched || format.Detect == nil || format.OptionalSignature {
+				byExtension = append(byExtension, format)
+			}
+		}
+	}
+
+	if len(byExtension) == 1 {
+		return byExtension[0], nil
+	}
+
+	if len(matches) == 1 {
+		return matches[0], nil
+	} else if len(matches) == 0 {
+		return nil, fmt.Errorf("%s: unrecognized level format (signature: %s)", filename, signature)
+	}
+
+	names := []string{}
+	for _, format := range matches {
+		names = append(names, format.Name)
+	}
+
+	return nil, fmt.Errorf(
+		"%s: ambiguous level format, could be any of %s (signature: %s)",
+		filename,
+		strings.Join(names, ", "),
+		signature)
+}
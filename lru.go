@@ -0,0 +1,88 @@
+package main
+
+import (
+	"container/list"
+)
+
+type lruEntry struct {
+	key   string
+	value interface{}
+	size  int
+}
+
+// lruCache holds values up to a total size, evicting the least recently used
+// ones to make room.  It isn't safe for concurrent use; callers hold their own
+// lock.
+type lruCache struct {
+	maxBytes int
+	size     int
+	entries  map[string]*list.Element
+	order    *list.List
+}
+
+func newLRUCache(maxBytes int) *lruCache {
+	return &lruCache{
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (c *lruCache) get(key string) (interface{}, bool) {
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+
+	return elem.Value.(*lruEntry).value, true
+}
+
+// add stores a value, replacing any already stored for key.  Values larger
+// than the whole cache aren't stored.
+func (c *lruCache) add(key string, value interface{}, size int) {
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	if size > c.maxBytes {
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry{key, value, size})
+	c.size += size
+
+	for c.size > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+}
+
+// grow adds to the size of the value stored for key, if it is still value.
+func (c *lruCache) grow(key string, value interface{}, size int) {
+	elem, ok := c.entries[key]
+	if !ok || elem.Value.(*lruEntry).value != value {
+		return
+	}
+
+	elem.Value.(*lruEntry).size += size
+	c.size += size
+	for c.size > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *lruCache) remove(elem *list.Element) {
+	entry := elem.Value.(*lruEntry)
+	c.order.Remove(elem)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+}
+
+func (c *lruCache) len() int {
+	return len(c.entries)
+}
+
+func (c *lruCache) purge() {
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+	c.size = 0
+}
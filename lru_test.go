@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLRUCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newLRUCache(10)
+	c.add("a", 1, 4)
+	c.add("b", 2, 4)
+	if _, ok := c.get("a"); !ok {
+		t.Fatal("a missing")
+	}
+	c.add("c", 3, 4)
+
+	if _, ok := c.get("b"); ok {
+		t.Error("b should have been evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.get(key); !ok {
+			t.Errorf("%s missing", key)
+		}
+	}
+	if c.size > 10 {
+		t.Errorf("size %d exceeds limit", c.size)
+	}
+}
+
+func TestLRUCacheReplaces(t *testing.T) {
+	c := newLRUCache(10)
+	c.add("a", 1, 4)
+	c.add("a", 2, 6)
+	if value, ok := c.get("a"); !ok || value != 2 {
+		t.Fatalf("got %v, %v, want 2", value, ok)
+	}
+	if c.size != 6 {
+		t.Errorf("size %d, want 6", c.size)
+	}
+
+	c.add("big", 3, 11)
+	if _, ok := c.get("big"); ok || c.len() != 1 {
+		t.Error("value larger than the cache was stored")
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Levels can be listed either in a CSV file (name, path, date and optionally
+// format) or in a JSON file, which can carry additional metadata:
+//
+//   {
+//     "levels": [
+//       {
+//         "name": "castle",
+//         "path": "levels/castle.dat",
+//         "date": "June 2010",
+//         "author": "someone",
+//         "description": "A castle on a hill",
+//         "tags": ["castle", "medieval"],
+//         "server": "Some Freebuild",
+//         "original_filename": "server_level.dat",
+//         "spawn": {"x": 128, "y": 40, "z": 128, "yaw": 90, "pitch": 0},
+//         "environment": "night",
+//         "hidden": false
+//       }
+//     ]
+//   }
+//
+// Spawn coordinates are in blocks, and yaw/pitch are in degrees.
+
+type jsonManifest struct {
+	Levels []jsonManifestEntry `json:"levels"`
+}
+
+type jsonManifestEntry struct {
+	Name             string             `json:"name"`
+	Path             string             `json:"path"`
+	Date             string             `json:"date"`
+	Format           string             `json:"format,omitempty"`
+	Author           string             `json:"author,omitempty"`
+	Description      string             `json:"description,omitempty"`
+	Tags             []string           `json:"tags,omitempty"`
+	Server           string             `json:"server,omitempty"`
+	OriginalFilename string             `json:"original_filename,omitempty"`
+	Spawn            *jsonManifestSpawn `json:"spawn,omitempty"`
+	Environment      string             `json:"environment,omitempty"`
+	Hidden           bool               `json:"hidden,omitempty"`
+}
+
+type jsonManifestSpawn struct {
+	X     int16   `json:"x"`
+	Y     int16   `json:"y"`
+	Z     int16   `json:"z"`
+	Yaw   float64 `json:"yaw"`
+	Pitch float64 `json:"pitch"`
+}
+
+// LevelSource provides the list of levels for a Museum.
+type LevelSource interface {
+	ReadLevels() ([]LevelDescriptor, error)
+	// Version returns a value that changes whenever the levels might have
+	// changed, so sources can be polled cheaply.
+	Version() (string, error)
+	String() string
+}
+
+type ManifestSource struct {
+	Filename string
+}
+
+func (src *ManifestSource) ReadLevels() ([]LevelDescriptor, error) {
+	return readManifest(src.Filename)
+}
+
+func (src *ManifestSource) Version() (string, error) {
+	stat, err := os.Stat(src.Filename)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%d:%d", stat.ModTime().UnixNano(), stat.Size()), nil
+}
+
+func (src *ManifestSource) String() string {
+	return src.Filename
+}
+
+func readManifest(filename string) ([]LevelDescriptor, error) {
+	if strings.ToLower(filepath.Ext(filename)) == ".json" {
+		return readJSONManifest(filename)
+	}
+
+	return readCSVManifest(filename)
+}
+
+func WriteManifest(filename string, levels []LevelDescriptor) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if strings.ToLower(filepath.Ext(filename)) == ".json" {
+		err = writeJSONManifest(file, levels)
+	} else {
+		err = writeCSVManifest(file, levels)
+	}
+	if err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+func readCSVManifest(filename string) ([]LevelDescriptor, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+
+	lines, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	levels := []LevelDescriptor{}
+	for _, line := range lines {
+		// Optional 4th column overrides level format detection
+		if len(line) != 3 && len(line) != 4 {
+			return nil, errors.New("manifest file is corrupt")
+		}
+
+		level := LevelDescriptor{
+			Name:       line[0],
+			Path:       line[1],
+			Datestring: line[2],
+		}
+		if len(line) == 4 && line[3] != "" {
+			if !IsLevelFormat(line[3]) {
+				return nil, fmt.Errorf("manifest entry %s has unknown level format %s", line[0], line[3])
+			}
+			level.Format = line[3]
+		}
+
+		levels = append(levels, level)
+	}
+
+	return levels, nil
+}
+
+func readJSONManifest(filename string) ([]LevelDescriptor, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+
+	var manifest jsonManifest
+	if err := decoder.Decode(&manifest); err != nil {
+		return nil, err
+	}
+
+	levels := []LevelDescriptor{}
+	for i, entry := range manifest.Levels {
+		if entry.Name == "" || entry.Path == "" {
+			return nil, fmt.Errorf("manifest entry %d is missing a name or path", i+1)
+		}
+		if strings.Contains(entry.Name, " ") {
+			return nil, fmt.Errorf("manifest entry %s: level names cannot contain spaces", entry.Name)
+		}
+		if entry.Format != "" && !IsLevelFormat(entry.Format) {
+			return nil, fmt.Errorf("manifest entry %s has unknown level format %s", entry.Name, entry.Format)
+		}
+		if entry.Environment != "" && !IsEnvironment(entry.Environment) {
+			return nil, fmt.Errorf("manifest entry %s has unknown environment %s (expected one of %s)",
+				entry.Name, entry.Environment, strings.Join(EnvironmentNames(), ", "))
+		}
+
+		level := LevelDescriptor{
+			Name:             entry.Name,
+			Path:             entry.Path,
+			Datestring:       entry.Date,
+			Format:           entry.Format,
+			Author:           entry.Author,
+			Description:      entry.Description,
+			Tags:             entry.Tags,
+			Server:           entry.Server,
+			OriginalFilename: entry.OriginalFilename,
+			Environment:      entry.Environment,
+			Hidden:           entry.Hidden,
+		}
+
+		if entry.Spawn != nil {
+			level.Spawn = &Spawnpoint{
+				X:    entry.Spawn.X<<5 + 16,
+				Y:    entry.Spawn.Y << 5,
+				Z:    entry.Spawn.Z<<5 + 16,
+				RotX: degreesToByte(entry.Spawn.Yaw),
+				RotY: degreesToByte(entry.Spawn.Pitch),
+			}
+		}
+
+		levels = append(levels, level)
+	}
+
+	return levels, nil
+}
+
+func writeCSVManifest(w io.Writer, levels []LevelDescriptor) error {
+	writer := csv.NewWriter(w)
+	for _, level := range levels {
+		if err := writer.Write([]string{level.Name, level.Path, level.Datestring, level.Format}); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeJSONManifest(w io.Writer, levels []LevelDescriptor) error {
+	manifest := jsonManifest{Levels: []jsonManifestEntry{}}
+	for _, level := range levels {
+		entry := jsonManifestEntry{
+			Name:             level.Name,
+			Path:             level.Path,
+			Date:             level.Datestring,
+			Format:           level.Format,
+			Author:           level.Author,
+			Description:      level.Description,
+			Tags:             level.Tags,
+			Server:           level.Server,
+			OriginalFilename: level.OriginalFilename,
+			Environment:      level.Environment,
+			Hidden:           level.Hidden,
+		}
+
+		if level.Spawn != nil {
+			entry.Spawn = &jsonManifestSpawn{
+				X:     level.Spawn.X >> 5,
+				Y:     level.Spawn.Y >> 5,
+				Z:     level.Spawn.Z >> 5,
+				Yaw:   byteToDegrees(level.Spawn.RotX),
+				Pitch: byteToDegrees(level.Spawn.RotY),
+			}
+		}
+
+		manifest.Levels = append(manifest.Levels, entry)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(manifest)
+}
+
+func degreesToByte(degrees float64) byte {
+	return byte(int(degrees*256/360) & 0xff)
+}
+
+func byteToDegrees(b byte) float64 {
+	return float64(b) * 360 / 256
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// MCSharp (and MCGalaxy) .lvl files are gzipped, little endian, with a short
+// header followed by the block array in the same order as Classic.  The
+// original format had no signature, later versions start with 1874.  Spawn
+// coordinates are stored in blocks.  Blocks can include MCSharp's own
+// physics and op blocks, which are converted on load.
+
+const lvlMagic = 1874
+
+func init() {
+	RegisterLevelFormat(&LevelFormat{
+		Name:       LevelFormatMCSharp,
+		Extensions: []string{".lvl"},
+		Gzipped:    true,
+		Detect: func(header []byte) bool {
+			return len(header) >= 2 && binary.LittleEndian.Uint16(header) == lvlMagic
+		},
+		OptionalSignature: true,
+		Loader:            LevelLoaderFunc(readMCSharpLevel),
+		Writer:            LevelWriterFunc(writeMCSharpLevel),
+	})
+}
+
+func readMCSharpLevel(r io.Reader) (*Level, error) {
+	var first uint16
+	if err := binary.Read(r, binary.LittleEndian, &first); err != nil {
+		return nil, err
+	}
+
+	// Note the header stores the vertical axis last
+	var header struct {
+		Width  uint16
+		Height uint16
+		Depth  uint16
+		SpawnX uint16
+		SpawnZ uint16
+		SpawnY uint16
+		RotX   byte
+		RotY   byte
+	}
+
+	if first == lvlMagic {
+		if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+			return nil, err
+		}
+
+		// Visit and build permissions
+		if _, err := io.ReadFull(r, make([]byte, 2)); err != nil {
+			return nil, err
+		}
+	} else {
+		header.Width = first
+		rest := []interface{}{
+			&header.Height, &header.Depth,
+			&header.SpawnX, &header.SpawnZ, &header.SpawnY,
+			&header.RotX, &header.RotY,
+		}
+		for _, field := range rest {
+			if err := binary.Read(r, binary.LittleEndian, field); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if header.Width > 0x7fff || header.Depth > 0x7fff || header.Height > 0x7fff {
+		return nil, errors.New("readMCSharpLevel: level too large")
+	}
+
+	lvl := &Level{
+		Width:  int16(header.Width),
+		Depth:  int16(header.Depth),
+		Height: int16(header.Height),
+		Spawn: Spawnpoint{
+			X:    int16(header.SpawnX)<<5 + 16,
+			Y:    int16(header.SpawnY) << 5,
+			Z:    int16(header.SpawnZ)<<5 + 16,
+			RotX: header.RotX,
+			RotY: header.RotY,
+		},
+	}
+	if err := lvl.checkDimensions(); err != nil {
+		return nil, err
+	}
+
+	// Newer MCGalaxy files have extra sections after the blocks, which are
+	// ignored
+	lvl.Blocks = make([]byte, lvl.Volume())
+	if _, err := io.ReadFull(r, lvl.Blocks); err != nil {
+		return nil, err
+	}
+	convertMCSharpBlocks(lvl.Blocks)
+
+	return lvl, nil
+}
+
+// writeMCSharpLevel saves a level with the 1874 signature, which MCSharp and
+// MCGalaxy both read.  The format has no metadata.
+func writeMCSharpLevel(w io.Writer, lvl *Level) error {
+	header := struct {
+		Magic       uint16
+		Width       uint16
+		Height      uint16
+		Depth       uint16
+		SpawnX      uint16
+		SpawnZ      uint16
+		SpawnY      uint16
+		RotX        byte
+		RotY        byte
+		Permissions [2]byte
+	}{
+		Magic:  lvlMagic,
+		Width:  uint16(lvl.Width),
+		Height: uint16(lvl.Height),
+		Depth:  uint16(lvl.Depth),
+		SpawnX: uint16(lvl.Spawn.X >> 5),
+		SpawnZ: uint16(lvl.Spawn.Z >> 5),
+		SpawnY: uint16(lvl.Spawn.Y >> 5),
+		RotX:   lvl.Spawn.RotX,
+		RotY:   lvl.Spawn.RotY,
+	}
+	if err := binary.Write(w, binary.LittleEndian, header); err != nil {
+		return err
+	}
+
+	_, err := w.Write(lvl.Blocks)
+	return err
+}
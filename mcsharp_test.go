@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestMCSharpBlocksConverted(t *testing.T) {
+	blocks := []byte{
+		3,   // dirt, unchanged
+		100, // op_glass
+		101, // opsidian
+		105, // op_air
+		111, // door
+		130, // white message block
+		52,  // CustomBlocks sandstone
+		199, // unknown
+	}
+	want := []byte{3, 20, 49, 0, 17, 36, 12, mcsharpUnknownBlock}
+
+	lvl := &Level{Width: int16(len(blocks)), Depth: 1, Height: 1, Blocks: blocks}
+	buf := new(bytes.Buffer)
+	if err := writeMCSharpLevel(buf, lvl); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readMCSharpLevel(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Blocks, want) {
+		t.Fatalf("got blocks %v, want %v", got.Blocks, want)
+	}
+	if problems := ValidateLevel(got); len(problems) != 0 {
+		t.Fatalf("converted level has problems: %v", problems)
+	}
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
-	"encoding/csv"
 	"errors"
+	"log"
 	"os"
+	"sync"
+	"time"
 )
 
 var ErrLevelNotFound = errors.New("level not found")
@@ -12,59 +14,185 @@ type LevelDescriptor struct {
 	Name       string
 	Path       string
 	Datestring string
+	Format     string
+
+	// Only set by structured manifests
+	Description      string
+	Tags             []string
+	Server           string
+	OriginalFilename string
+	Spawn            *Spawnpoint
+	Environment      string
+	// Hidden levels aren't listed, but can still be visited with /goto
+	Hidden bool
+
+	// Taken from the manifest if set there, otherwise from metadata embedded
+	// in the level file once it has been loaded
+	Author    string
+	WorldName string
+	Created   time.Time
+
+	// Only known once the level has been read, either by the museum or by
+	// whatever read the level list
+	Width, Depth, Height int16
+}
+
+// Date returns the manifest date, falling back to the creation date embedded
+// in the level file.
+func (d LevelDescriptor) Date() string {
+	if d.Datestring == "" && !d.Created.IsZero() {
+		return d.Created.UTC().Format("January 2, 2006")
+	}
+
+	return d.Datestring
+}
+
+func (d *LevelDescriptor) applyMetadata(lvl *Level) {
+	if d.Author == "" {
+		d.Author = lvl.Creator
+	}
+	if d.WorldName == "" {
+		d.WorldName = lvl.Name
+	}
+	if d.Created.IsZero() {
+		d.Created = lvl.CreateTime
+	}
+	d.Width, d.Depth, d.Height = lvl.Width, lvl.Depth, lvl.Height
 }
 
 type Museum struct {
-	Name   string
-	MOTD   string
+	Name string
+	MOTD string
+
+	source        LevelSource
+	sourceVersion string
+
+	mutex  sync.Mutex
 	levels []LevelDescriptor
+	cache  *LevelCache
+	rooms  map[string]*Room
+	// Incremented on every reload, so anything derived from level files
+	// can tell when it is out of date
+	generation int
+	// What readMetadata found, by path, so unchanged files aren't read again
+	// after a reload
+	metadata map[string]levelMetadata
+}
+
+type levelMetadata struct {
+	modTime time.Time
+	// Without its blocks
+	level *Level
 }
 
-func NewMuseum(name, motd, manifestFilename string) (*Museum, error) {
-	file, err := os.Open(manifestFilename)
+func NewMuseum(name, motd string, source LevelSource, cacheSize int) (*Museum, error) {
+	version, err := source.Version()
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
-
-	reader := csv.NewReader(file)
 
-	lines, err := reader.ReadAll()
+	levels, err := source.ReadLevels()
 	if err != nil {
 		return nil, err
 	}
 
-	levels := []LevelDescriptor{}
-	for _, line := range lines {
-		if len(line) != 3 {
-			return nil, errors.New("manifest file is corrupt")
+	m := &Museum{
+		Name:          name,
+		MOTD:          motd,
+		source:        source,
+		sourceVersion: version,
+		levels:        levels,
+		cache:         NewLevelCache(cacheSize),
+		rooms:         make(map[string]*Room),
+		metadata:      make(map[string]levelMetadata),
+	}
+	go m.readMetadata(append([]LevelDescriptor{}, levels...), 0)
+
+	return m, nil
+}
+
+// readMetadata fills in the dimensions and embedded metadata of levels that
+// haven't been loaded yet, so they can be listed without loading them into
+// the cache.  Levels are read one at a time, bypassing the cache, until the
+// levels are reloaded and a newer pass takes over.
+func (m *Museum) readMetadata(levels []LevelDescriptor, generation int) {
+	for _, level := range levels {
+		if level.Width != 0 {
+			continue
+		}
+		if m.Generation() != generation {
+			return
 		}
 
-		levels = append(levels, LevelDescriptor{
-			Name:       line[0],
-			Path:       line[1],
-			Datestring: line[2],
-		})
+		info, err := os.Stat(level.Path)
+		if err != nil {
+			log.Printf("[ERROR] Failed to read metadata of %s: %s", level.Name, err.Error())
+			continue
+		}
+		lvl, err := ReadLevelFormat(level.Path, level.Format)
+		if err != nil {
+			log.Printf("[ERROR] Failed to read metadata of %s: %s", level.Name, err.Error())
+			continue
+		}
+		lvl.Blocks = nil
+
+		m.mutex.Lock()
+		if m.generation != generation {
+			m.mutex.Unlock()
+			return
+		}
+		m.metadata[level.Path] = levelMetadata{info.ModTime(), lvl}
+		for i := range m.levels {
+			if m.levels[i].Name == level.Name && m.levels[i].Path == level.Path {
+				m.levels[i].applyMetadata(lvl)
+			}
+		}
+		m.mutex.Unlock()
 	}
+}
 
-	return &Museum{
-		Name:   name,
-		MOTD:   motd,
-		levels: levels,
-	}, nil
+// Generation returns a number that changes whenever the levels are reloaded.
+func (m *Museum) Generation() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.generation
 }
 
 func (m *Museum) ListLevelNames() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	names := []string{}
 
 	for _, level := range m.levels {
-		names = append(names, level.Name)
+		if !level.Hidden {
+			names = append(names, level.Name)
+		}
 	}
 
 	return names
 }
 
+// Levels returns the descriptors of every level that isn't hidden.
+func (m *Museum) Levels() []LevelDescriptor {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	levels := []LevelDescriptor{}
+	for _, level := range m.levels {
+		if !level.Hidden {
+			levels = append(levels, level)
+		}
+	}
+
+	return levels
+}
+
 func (m *Museum) GetLevel(name string) (LevelDescriptor, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	for _, level := range m.levels {
 		if level.Name == name {
 			return level, nil
@@ -75,9 +203,147 @@ func (m *Museum) GetLevel(name string) (LevelDescriptor, error) {
 }
 
 func (m *Museum) GetDefaultLevel() (LevelDescriptor, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	for _, level := range m.levels {
-		return level, nil
+		if !level.Hidden {
+			return level, nil
+		}
 	}
 
 	return LevelDescriptor{}, ErrLevelNotFound
 }
+
+// LoadLevel returns a level from the cache, reading it from disk if needed,
+// and records any metadata embedded in the level file in its descriptor.
+func (m *Museum) LoadLevel(level LevelDescriptor) (*CachedLevel, LevelDescriptor, error) {
+	key := level.Format + ":" + level.Path
+	cached, err := m.cache.Get(key, func() (*CachedLevel, error) {
+		lvl, err := ReadLevelFormat(level.Path, level.Format)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewCachedLevel(lvl), nil
+	})
+	if err != nil {
+		return nil, level, err
+	}
+
+	level.applyMetadata(cached.Level)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i := range m.levels {
+		if m.levels[i].Name == level.Name {
+			m.levels[i].applyMetadata(cached.Level)
+		}
+	}
+
+	return cached, level, nil
+}
+
+// Reload re-reads the level list from the museum's source, and returns the
+// names of any newly added levels.  If the list can't be read, the current
+// levels are kept.
+func (m *Museum) Reload() ([]string, error) {
+	version, err := m.source.Version()
+	if err != nil {
+		return nil, err
+	}
+
+	levels, err := m.source.ReadLevels()
+	if err != nil {
+		return nil, err
+	}
+	if len(levels) == 0 {
+		return nil, errors.New("no levels found")
+	}
+
+	modTimes := make(map[string]time.Time)
+	for _, level := range levels {
+		if info, err := os.Stat(level.Path); err == nil {
+			modTimes[level.Path] = info.ModTime()
+		}
+	}
+
+	m.mutex.Lock()
+	old := make(map[string]bool)
+	for _, level := range m.levels {
+		old[level.Name] = true
+	}
+
+	added := []string{}
+	for _, level := range levels {
+		if !old[level.Name] && !level.Hidden {
+			added = append(added, level.Name)
+		}
+	}
+
+	// Metadata is kept for files that haven't changed
+	metadata := make(map[string]levelMetadata)
+	for i := range levels {
+		known, ok := m.metadata[levels[i].Path]
+		if !ok || !known.modTime.Equal(modTimes[levels[i].Path]) {
+			continue
+		}
+		metadata[levels[i].Path] = known
+		if levels[i].Width == 0 {
+			levels[i].applyMetadata(known.level)
+		}
+	}
+
+	m.levels = levels
+	m.metadata = metadata
+	m.sourceVersion = version
+	m.generation++
+	generation := m.generation
+	m.mutex.Unlock()
+
+	// Level files may have been replaced too
+	m.cache.Purge()
+	go m.readMetadata(append([]LevelDescriptor{}, levels...), generation)
+
+	log.Printf("Reloaded %s: %d levels, %d new", m.source, len(levels), len(added))
+	return added, nil
+}
+
+// WatchLevels reloads the level list whenever the source changes (checked
+// every interval, if nonzero) or when a signal is received on reload, and
+// calls notify with the names of any new levels.
+func (m *Museum) WatchLevels(interval time.Duration, reload <-chan os.Signal, notify func(added []string)) {
+	var poll <-chan time.Time
+	if interval > 0 {
+		poll = time.NewTicker(interval).C
+	}
+
+	for {
+		select {
+		case <-poll:
+			version, err := m.source.Version()
+			if err != nil {
+				log.Printf("[ERROR] Failed to check %s: %s", m.source, err.Error())
+				continue
+			}
+
+			m.mutex.Lock()
+			unchanged := version == m.sourceVersion
+			m.mutex.Unlock()
+
+			if unchanged {
+				continue
+			}
+		case sig := <-reload:
+			log.Printf("Received %s, reloading %s", sig, m.source)
+		}
+
+		added, err := m.Reload()
+		if err != nil {
+			log.Printf("[ERROR] Failed to reload %s, keeping current levels: %s", m.source, err.Error())
+		} else if len(added) > 0 {
+			notify(added)
+		}
+	}
+}
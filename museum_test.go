@@ -0,0 +1,148 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testSource []LevelDescriptor
+
+func (src testSource) ReadLevels() ([]LevelDescriptor, error) {
+	return append([]LevelDescriptor{}, src...), nil
+}
+
+func (src testSource) Version() (string, error) {
+	return "", nil
+}
+
+func (src testSource) String() string {
+	return "test levels"
+}
+
+func TestMuseumReadsMetadataWithoutCaching(t *testing.T) {
+	dir, err := ioutil.TempDir("", "museum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lvl := &Level{Width: 32, Depth: 16, Height: 64}
+	lvl.Blocks = make([]byte, lvl.Volume())
+	path := filepath.Join(dir, "test.dump")
+	if err := WriteLevelFormat(path, lvl, LevelFormatDump); err != nil {
+		t.Fatal(err)
+	}
+
+	source := testSource{
+		{Name: "test", Path: path, Format: LevelFormatDump},
+		{Name: "missing", Path: filepath.Join(dir, "missing.dump"), Format: LevelFormatDump},
+	}
+	m, err := NewMuseum("Museum", "", source, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		level, err := m.GetLevel("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if level.Width != 0 {
+			if level.Width != 32 || level.Depth != 16 || level.Height != 64 {
+				t.Fatalf("got %d x %d x %d, want 32 x 16 x 64", level.Width, level.Depth, level.Height)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("dimensions were never read")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	m.cache.mutex.Lock()
+	defer m.cache.mutex.Unlock()
+	if n := m.cache.lru.len(); n != 0 {
+		t.Fatalf("%d levels cached, want none", n)
+	}
+}
+
+func writeMuseumTestLevel(t *testing.T, path string) {
+	lvl := &Level{Width: 32, Depth: 16, Height: 64}
+	lvl.Blocks = make([]byte, lvl.Volume())
+	if err := WriteLevelFormat(path, lvl, LevelFormatDump); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMuseumStaleMetadataPassStops(t *testing.T) {
+	dir, err := ioutil.TempDir("", "museum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.dump")
+	writeMuseumTestLevel(t, path)
+	levels := []LevelDescriptor{{Name: "test", Path: path, Format: LevelFormatDump}}
+	m := &Museum{
+		levels:     append([]LevelDescriptor{}, levels...),
+		metadata:   make(map[string]levelMetadata),
+		generation: 1,
+	}
+
+	m.readMetadata(levels, 0)
+	if m.levels[0].Width != 0 || len(m.metadata) != 0 {
+		t.Fatal("pass from before the reload was applied")
+	}
+
+	m.readMetadata(levels, 1)
+	if m.levels[0].Width != 32 {
+		t.Fatalf("got width %d, want 32", m.levels[0].Width)
+	}
+}
+
+func TestMuseumReloadKeepsMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "museum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.dump")
+	writeMuseumTestLevel(t, path)
+	source := testSource{{Name: "test", Path: path, Format: LevelFormatDump}}
+	m := &Museum{
+		source:   source,
+		levels:   source,
+		cache:    NewLevelCache(0),
+		metadata: make(map[string]levelMetadata),
+	}
+	m.readMetadata(source, 0)
+
+	// Unreadable, but apparently unchanged, so it mustn't be read again
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	level, err := m.GetLevel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level.Width != 32 || level.Depth != 16 || level.Height != 64 {
+		t.Fatalf("got %d x %d x %d after reload, want 32 x 16 x 64", level.Width, level.Depth, level.Height)
+	}
+}
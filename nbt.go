@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Named Binary Tag codec.  Tags are decoded into plain Go values:
+//
+//   TAG_Byte       int8
+//   TAG_Short      int16
+//   TAG_Int        int32
+//   TAG_Long       int64
+//   TAG_Float      float32
+//   TAG_Double     float64
+//   TAG_Byte_Array []byte
+//   TAG_String     string
+//   TAG_List       NBTList
+//   TAG_Compound   NBTCompound
+//   TAG_Int_Array  []int32
+//   TAG_Long_Array []int64
+
+const (
+	nbtEnd       = 0
+	nbtByte      = 1
+	nbtShort     = 2
+	nbtInt       = 3
+	nbtLong      = 4
+	nbtFloat     = 5
+	nbtDouble    = 6
+	nbtByteArray = 7
+	nbtString    = 8
+	nbtList      = 9
+	nbtCompound  = 10
+	nbtIntArray  = 11
+	nbtLongArray = 12
+)
+
+// Nesting limit, to avoid blowing the stack on malicious files
+const nbtMaxDepth = 512
+
+// Arrays are read this many elements at a time, so that a corrupt length
+// fails once the data runs out instead of allocating it all up front
+const nbtReadChunk = 1 << 16
+
+type NBTCompound map[string]interface{}
+
+type NBTList struct {
+	Type   byte
+	Values []interface{}
+}
+
+func ReadNBT(r io.Reader) (name string, root NBTCompound, err error) {
+	var tagType byte
+	if err = binary.Read(r, binary.BigEndian, &tagType); err != nil {
+		return
+	}
+	if tagType != nbtCompound {
+		err = errors.New("NBT root tag is not a compound")
+		return
+	}
+
+	if name, err = readNBTString(r); err != nil {
+		return
+	}
+
+	value, err := readNBTPayload(r, tagType, 0)
+	if err != nil {
+		return
+	}
+
+	return name, value.(NBTCompound), nil
+}
+
+func readNBTString(r io.Reader) (string, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+
+	return string(buf), nil
+}
+
+func readNBTLength(r io.Reader) (int, error) {
+	var length int32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return 0, err
+	}
+	if length < 0 {
+		return 0, errors.New("negative NBT length")
+	}
+
+	return int(length), nil
+}
+
+func nbtChunk(remaining int) int {
+	if remaining > nbtReadChunk {
+		return nbtReadChunk
+	}
+
+	return remaining
+}
+
+func readNBTPayload(r io.Reader, tagType byte, depth int) (interface{}, error) {
+	if depth > nbtMaxDepth {
+		return nil, errors.New("NBT nested too deeply")
+	}
+
+	switch tagType {
+	case nbtByte:
+		var v int8
+		err := binary.Read(r, binary.BigEndian, &v)
+		return v, err
+	case nbtShort:
+		var v int16
+		err := binary.Read(r, binary.BigEndian, &v)
+		return v, err
+	case nbtInt:
+		var v int32
+		err := binary.Read(r, binary.BigEndian, &v)
+		return v, err
+	case nbtLong:
+		var v int64
+		err := binary.Read(r, binary.BigEndian, &v)
+		return v, err
+	case nbtFloat:
+		var v float32
+		err := binary.Read(r, binary.BigEndian, &v)
+		return v, err
+	case nbtDouble:
+		var v float64
+		err := binary.Read(r, binary.BigEndian, &v)
+		return v, err
+	case nbtByteArray:
+		length, err := readNBTLength(r)
+		if err != nil {
+			return nil, err
+		}
+		v := []byte{}
+		for len(v) < length {
+			start := len(v)
+			v = append(v, make([]byte, nbtChunk(length-start))...)
+			if _, err := io.ReadFull(r, v[start:]); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	case nbtString:
+		return readNBTString(r)
+	case nbtList:
+		var elemType byte
+		if err := binary.Read(r, binary.BigEndian, &elemType); err != nil {
+			return nil, err
+		}
+		length, err := readNBTLength(r)
+		if err != nil {
+			return nil, err
+		}
+		list := NBTList{Type: elemType}
+		for i := 0; i < length; i++ {
+			value, err := readNBTPayload(r, elemType, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			list.Values = append(list.Values, value)
+		}
+		return list, nil
+	case nbtCompound:
+		compound := NBTCompound{}
+		for {
+			var childType byte
+			if err := binary.Read(r, binary.BigEndian, &childType); err != nil {
+				return nil, err
+			}
+			if childType == nbtEnd {
+				return compound, nil
+			}
+
+			name, err := readNBTString(r)
+			if err != nil {
+				return nil, err
+			}
+			value, err := readNBTPayload(r, childType, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			compound[name] = value
+		}
+	case nbtIntArray:
+		length, err := readNBTLength(r)
+		if err != nil {
+			return nil, err
+		}
+		v := []int32{}
+		for len(v) < length {
+			chunk := make([]int32, nbtChunk(length-len(v)))
+			if err := binary.Read(r, binary.BigEndian, chunk); err != nil {
+				return nil, err
+			}
+			v = append(v, chunk...)
+		}
+		return v, nil
+	case nbtLongArray:
+		length, err := readNBTLength(r)
+		if err != nil {
+			return nil, err
+		}
+		v := []int64{}
+		for len(v) < length {
+			chunk := make([]int64, nbtChunk(length-len(v)))
+			if err := binary.Read(r, binary.BigEndian, chunk); err != nil {
+				return nil, err
+			}
+			v = append(v, chunk...)
+		}
+		return v, nil
+	default:
+		return nil, fmt.Errorf("invalid NBT tag type %d", tagType)
+	}
+}
+
+func WriteNBT(w io.Writer, name string, root NBTCompound) error {
+	if err := binary.Write(w, binary.BigEndian, byte(nbtCompound)); err != nil {
+		return err
+	}
+	if err := writeNBTString(w, name); err != nil {
+		return err
+	}
+
+	return writeNBTPayload(w, root)
+}
+
+func nbtTagType(value interface{}) (byte, error) {
+	switch value.(type) {
+	case int8:
+		return nbtByte, nil
+	case int16:
+		return nbtShort, nil
+	case int32:
+		return nbtInt, nil
+	case int64:
+		return nbtLong, nil
+	case float32:
+		return nbtFloat, nil
+	case float64:
+		return nbtDouble, nil
+	case []byte:
+		return nbtByteArray, nil
+	case string:
+		return nbtString, nil
+	case NBTList:
+		return nbtList, nil
+	case NBTCompound:
+		return nbtCompound, nil
+	case []int32:
+		return nbtIntArray, nil
+	case []int64:
+		return nbtLongArray, nil
+	default:
+		return 0, fmt.Errorf("cannot encode %T as NBT", value)
+	}
+}
+
+func writeNBTString(w io.Writer, s string) error {
+	if len(s) > 0xffff {
+		return errors.New("NBT string too long")
+	}
+	if err := binary.Write(w, binary.BigEndian, uint16(len(s))); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, s)
+	return err
+}
+
+func writeNBTPayload(w io.Writer, value interface{}) error {
+	switch v := value.(type) {
+	case string:
+		return writeNBTString(w, v)
+	case []byte:
+		if err := binary.Write(w, binary.BigEndian, int32(len(v))); err != nil {
+			return err
+		}
+		_, err := w.Write(v)
+		return err
+	case []int32:
+		if err := binary.Write(w, binary.BigEndian, int32(len(v))); err != nil {
+			return err
+		}
+		return binary.Write(w, binary.BigEndian, v)
+	case []int64:
+		if err := binary.Write(w, binary.BigEndian, int32(len(v))); err != nil {
+			return err
+		}
+		return binary.Write(w, binary.BigEndian, v)
+	case NBTList:
+		if err := binary.Write(w, binary.BigEndian, v.Type); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.BigEndian, int32(len(v.Values))); err != nil {
+			return err
+		}
+		for _, elem := range v.Values {
+			if t, err := nbtTagType(elem); err != nil {
+				return err
+			} else if t != v.Type {
+				return errors.New("NBT list element does not match list type")
+			}
+			if err := writeNBTPayload(w, elem); err != nil {
+				return err
+			}
+		}
+		return nil
+	case NBTCompound:
+		// Sorted for deterministic output
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			t, err := nbtTagType(v[name])
+			if err != nil {
+				return err
+			}
+			if err := binary.Write(w, binary.BigEndian, t); err != nil {
+				return err
+			}
+			if err := writeNBTString(w, name); err != nil {
+				return err
+			}
+			if err := writeNBTPayload(w, v[name]); err != nil {
+				return err
+			}
+		}
+		return binary.Write(w, binary.BigEndian, byte(nbtEnd))
+	default:
+		if _, err := nbtTagType(value); err != nil {
+			return err
+		}
+		return binary.Write(w, binary.BigEndian, value)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestNBTRoundTrip(t *testing.T) {
+	want := NBTCompound{
+		"byte":      int8(-5),
+		"short":     int16(-300),
+		"int":       int32(70000),
+		"long":      int64(-1 << 40),
+		"float":     float32(1.5),
+		"double":    float64(-2.25),
+		"bytes":     []byte{1, 2, 3},
+		"string":    "hello",
+		"ints":      []int32{-1, 0, 1 << 30},
+		"longs":     []int64{-1 << 50, 7},
+		"emptyInts": []int32{},
+		"list": NBTList{Type: nbtShort, Values: []interface{}{
+			int16(1), int16(2),
+		}},
+		"compounds": NBTList{Type: nbtCompound, Values: []interface{}{
+			NBTCompound{"a": int8(1)},
+			NBTCompound{"b": NBTCompound{"c": "nested"}},
+		}},
+		"compound": NBTCompound{
+			"inner": NBTList{Type: nbtString, Values: []interface{}{"x", "y"}},
+		},
+	}
+
+	buf := new(bytes.Buffer)
+	if err := WriteNBT(buf, "root", want); err != nil {
+		t.Fatal(err)
+	}
+
+	name, got, err := ReadNBT(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "root" {
+		t.Errorf("got root name %q", name)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestNBTWriteErrors(t *testing.T) {
+	for _, root := range []NBTCompound{
+		{"int": 5},
+		{"list": NBTList{Type: nbtByte, Values: []interface{}{int16(1)}}},
+	} {
+		if err := WriteNBT(new(bytes.Buffer), "", root); err == nil {
+			t.Errorf("expected an error writing %#v", root)
+		}
+	}
+}
+
+// nbtStream returns a root compound with a single tag, whose payload is
+// given as raw bytes.
+func nbtStream(tagType byte, payload ...interface{}) []byte {
+	buf := new(bytes.Buffer)
+	buf.Write([]byte{nbtCompound, 0, 0, tagType, 0, 1, 'x'})
+	for _, v := range payload {
+		binary.Write(buf, binary.BigEndian, v)
+	}
+
+	return buf.Bytes()
+}
+
+func TestNBTNegativeLength(t *testing.T) {
+	for _, tagType := range []byte{nbtByteArray, nbtIntArray, nbtLongArray} {
+		_, _, err := ReadNBT(bytes.NewReader(nbtStream(tagType, int32(-1))))
+		if err == nil {
+			t.Errorf("tag type %d: expected an error for a negative length", tagType)
+		}
+	}
+
+	_, _, err := ReadNBT(bytes.NewReader(nbtStream(nbtList, byte(nbtByte), int32(-1))))
+	if err == nil {
+		t.Error("list: expected an error for a negative length")
+	}
+}
+
+func TestNBTTruncatedArray(t *testing.T) {
+	for _, tagType := range []byte{nbtByteArray, nbtIntArray, nbtLongArray} {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, _, err := ReadNBT(bytes.NewReader(nbtStream(tagType, int32(0x7fffffff), int64(0))))
+		runtime.ReadMemStats(&after)
+
+		if err != io.ErrUnexpectedEOF {
+			t.Errorf("tag type %d: got %v, want io.ErrUnexpectedEOF", tagType, err)
+		}
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 16<<20 {
+			t.Errorf("tag type %d: allocated %d bytes for a truncated array", tagType, allocated)
+		}
+	}
+}
+
+func TestNBTDepthLimit(t *testing.T) {
+	// Lists of lists, nested deeper than allowed
+	payload := []interface{}{}
+	for i := 0; i <= nbtMaxDepth; i++ {
+		payload = append(payload, byte(nbtList), int32(1))
+	}
+	payload = append(payload, byte(nbtByte), int32(0))
+
+	_, _, err := ReadNBT(bytes.NewReader(nbtStream(nbtList, payload...)))
+	if err == nil || err.Error() != "NBT nested too deeply" {
+		t.Errorf("got %v, want the nesting limit error", err)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// Everything sent to a client goes through its outbox, which is drained by a
+// separate writer goroutine.  That way a slow connection only holds up
+// itself, and never the player (or level load) that triggered the send.
+//
+// Other players' movement would fill the outbox while a slow client is still
+// downloading a level, so spawns, despawns and moves are coalesced: they are
+// collected in an entity batch, which takes up a single outbox slot and only
+// keeps the latest state of each player.  Queueing anything else closes the
+// batch, so that updates are never reordered around a level or a message.
+
+// Number of queued sends after which a client is considered stuck and is
+// disconnected.  A whole level is a single send.
+const outboxSize = 512
+
+// How long a closing client gets to flush what's left in its outbox
+const outboxDrainTimeout = 5 * time.Second
+
+// Each send (including a whole level) must finish within writeTimeout, so
+// that dead connections don't hold up the writer forever
+const writeTimeout = time.Minute
+
+// Logged in clients are pinged regularly, so that dead connections are
+// noticed even when nothing else is being sent
+const pingInterval = 15 * time.Second
+
+var errOutboxClosed = errors.New("outbox closed")
+
+// A queued send.  It runs on the writer goroutine, and must not touch any
+// client state that MainLoop might be changing.
+type outgoing func(enc *ServerEncoder) error
+
+func (c *Client) writeLoop() {
+	defer close(c.writerDone)
+
+	for {
+		select {
+		case send := <-c.outbox:
+			c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			err := send(c.encoder)
+			// Batch up whatever has been queued in the meantime
+			if err == nil && len(c.outbox) == 0 {
+				err = c.encoder.Flush()
+			}
+
+			if err == errOutboxClosed {
+				c.encoder.Flush()
+				return
+			} else if err != nil {
+				c.log("[ERROR] write failed: %s", err.Error())
+				c.disconnect()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// queue adds a send to the outbox, disconnecting the client if it's full.
+func (c *Client) queue(send outgoing) {
+	c.entityMutex.Lock()
+	defer c.entityMutex.Unlock()
+
+	c.entities = nil
+	c.push(send)
+}
+
+func (c *Client) push(send outgoing) {
+	if c.disconnected() {
+		return
+	}
+
+	select {
+	case c.outbox <- send:
+	default:
+		c.log("[ERROR] outbox full, disconnecting")
+		c.disconnect()
+	}
+}
+
+// entityUpdate is what is still to be sent about one entity: a despawn of
+// whatever the client last saw with that ID, then a spawn, then a move.
+type entityUpdate struct {
+	despawn bool
+	spawn   *SpawnPlayer
+	move    *SetPosition
+}
+
+type entityBatch struct {
+	updates map[int8]*entityUpdate
+	// Entity IDs in the order they were first updated
+	order []int8
+}
+
+// updateEntity records a change to an entity in the open batch, queueing a
+// new batch if there isn't one.
+func (c *Client) updateEntity(id int8, update func(u *entityUpdate)) {
+	c.entityMutex.Lock()
+	defer c.entityMutex.Unlock()
+
+	batch := c.entities
+	if batch == nil {
+		batch = &entityBatch{updates: make(map[int8]*entityUpdate)}
+		c.entities = batch
+		c.push(func(enc *ServerEncoder) error {
+			return c.writeEntities(enc, batch)
+		})
+	}
+
+	u, ok := batch.updates[id]
+	if !ok {
+		u = &entityUpdate{}
+		batch.updates[id] = u
+		batch.order = append(batch.order, id)
+	}
+	update(u)
+}
+
+func (c *Client) writeEntities(enc *ServerEncoder, batch *entityBatch) error {
+	c.entityMutex.Lock()
+	if c.entities == batch {
+		c.entities = nil
+	}
+	updates := make([]entityUpdate, len(batch.order))
+	for i, id := range batch.order {
+		updates[i] = *batch.updates[id]
+	}
+	c.entityMutex.Unlock()
+
+	for i, u := range updates {
+		id := batch.order[i]
+		if u.despawn {
+			if err := enc.WriteDespawnPlayer(id); err != nil {
+				return err
+			}
+		}
+		if p := u.spawn; p != nil {
+			if err := enc.WriteSpawnPlayer(id, p.Name, p.X, p.Y, p.Z, p.Yaw, p.Pitch); err != nil {
+				return err
+			}
+		}
+		if p := u.move; p != nil {
+			if err := enc.WriteTeleportPlayer(id, p.X, p.Y, p.Z, p.Yaw, p.Pitch); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) sendSpawnPlayer(id int8, name string, x, y, z int16, yaw, pitch byte) {
+	c.updateEntity(id, func(u *entityUpdate) {
+		u.spawn = &SpawnPlayer{id, name, x, y, z, yaw, pitch}
+		u.move = nil
+	})
+}
+
+func (c *Client) sendTeleportPlayer(id int8, x, y, z int16, yaw, pitch byte) {
+	c.updateEntity(id, func(u *entityUpdate) {
+		if u.spawn != nil {
+			u.spawn.X, u.spawn.Y, u.spawn.Z = x, y, z
+			u.spawn.Yaw, u.spawn.Pitch = yaw, pitch
+		} else {
+			u.move = &SetPosition{id, x, y, z, yaw, pitch}
+		}
+	})
+}
+
+func (c *Client) sendDespawnPlayer(id int8) {
+	c.updateEntity(id, func(u *entityUpdate) {
+		*u = entityUpdate{despawn: true}
+	})
+}
+
+func (c *Client) pingLoop() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.queue(func(enc *ServerEncoder) error {
+				return enc.WritePacket(&Ping{})
+			})
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// disconnect closes the connection immediately, discarding anything left in
+// the outbox.
+func (c *Client) disconnect() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+func (c *Client) disconnected() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// close gives the writer a chance to send what's already queued (such as a
+// kick message) before closing the connection.
+func (c *Client) close() {
+	c.queue(func(enc *ServerEncoder) error {
+		return errOutboxClosed
+	})
+
+	select {
+	case <-c.writerDone:
+	case <-time.After(outboxDrainTimeout):
+		c.log("[ERROR] timed out flushing outbox")
+	}
+
+	c.disconnect()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func newTestClient(w io.Writer) *Client {
+	return &Client{
+		encoder:    NewServerEncoder(w),
+		outbox:     make(chan outgoing, outboxSize),
+		done:       make(chan struct{}),
+		writerDone: make(chan struct{}),
+	}
+}
+
+// drainOutbox runs everything queued for c, as the writer would, and returns
+// the packets sent.
+func drainOutbox(t *testing.T, c *Client, buf *bytes.Buffer) []Packet {
+	for len(c.outbox) > 0 {
+		send := <-c.outbox
+		if err := send(c.encoder); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.encoder.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	packets := []Packet{}
+	r := bufio.NewReader(buf)
+	for {
+		p, err := ReadPacket(r, ServerPackets)
+		if err == io.EOF {
+			return packets
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, p)
+	}
+}
+
+func TestOutboxCoalescesMovement(t *testing.T) {
+	buf := new(bytes.Buffer)
+	c := newTestClient(buf)
+
+	// A level that is still being sent, while three players keep moving
+	c.queue(func(enc *ServerEncoder) error {
+		return enc.WriteLevelInit()
+	})
+	for i := 0; i < 10*outboxSize; i++ {
+		for id := int8(0); id < 3; id++ {
+			c.sendTeleportPlayer(id, int16(i), int16(id), 0, 0, 0)
+		}
+	}
+
+	if c.disconnected() {
+		t.Fatal("client was disconnected")
+	}
+	if len(c.outbox) != 2 {
+		t.Fatalf("%d sends queued, want 2", len(c.outbox))
+	}
+
+	want := []Packet{&LevelInit{}}
+	for id := int8(0); id < 3; id++ {
+		want = append(want, &SetPosition{id, 10*outboxSize - 1, int16(id), 0, 0, 0})
+	}
+	if got := drainOutbox(t, c, buf); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestOutboxKeepsOrder(t *testing.T) {
+	buf := new(bytes.Buffer)
+	c := newTestClient(buf)
+
+	c.sendSpawnPlayer(1, "alice", 1, 2, 3, 0, 0)
+	c.sendTeleportPlayer(1, 4, 5, 6, 0, 0)
+	c.sendDespawnPlayer(2)
+	c.sendSpawnPlayer(2, "bob", 0, 0, 0, 0, 0)
+	c.SendMessage("hello", MessageSenderServer)
+	c.sendTeleportPlayer(1, 7, 8, 9, 0, 0)
+	c.sendDespawnPlayer(1)
+
+	want := []Packet{
+		&SpawnPlayer{1, "alice", 4, 5, 6, 0, 0},
+		&DespawnPlayer{2},
+		&SpawnPlayer{2, "bob", 0, 0, 0, 0, 0},
+		&Message{MessageSenderServer, "hello"},
+		&DespawnPlayer{1},
+	}
+	if got := drainOutbox(t, c, buf); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
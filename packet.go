@@ -0,0 +1,1101 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Packet is a single Classic (or CPE) protocol packet.  Every packet has a
+// fixed size, so a packet ID and a table of sizes is all that's needed to
+// frame them.  See https://wiki.vg/Classic_Protocol
+type Packet interface {
+	ID() byte
+	// Size of the packet, not counting the packet ID
+	Size() int
+	Encode(b *PacketBuffer)
+	Decode(b *PacketBuffer)
+}
+
+type packetType struct {
+	size      int
+	newPacket func() Packet
+}
+
+// PacketTable maps the packet IDs one side of a connection may send to their
+// sizes and constructors.
+type PacketTable map[byte]packetType
+
+// newPacketTable records the size of each packet up front, so that framing a
+// packet doesn't need a throwaway instance of it.
+func newPacketTable(constructors map[byte]func() Packet) PacketTable {
+	table := make(PacketTable)
+	for id, newPacket := range constructors {
+		table[id] = packetType{newPacket().Size(), newPacket}
+	}
+
+	return table
+}
+
+// Packets sent by clients.  The CPE packets are understood here, but the
+// extensions that use them aren't offered, so well-behaved clients don't
+// send them.
+var ClientPackets = newPacketTable(map[byte]func() Packet{
+	PacketClientHello:                   func() Packet { return &ClientHello{} },
+	PacketClientSetBlock:                func() Packet { return &ClientSetBlock{} },
+	PacketClientPositionUpdate:          func() Packet { return &SetPosition{} },
+	PacketClientMessage:                 func() Packet { return &Message{} },
+	PacketClientExtInfo:                 func() Packet { return &ExtInfo{} },
+	PacketClientExtEntry:                func() Packet { return &ExtEntry{} },
+	PacketClientCustomBlockSupportLevel: func() Packet { return &CustomBlockSupportLevel{} },
+	PacketClientPlayerClicked:           func() Packet { return &PlayerClicked{} },
+	PacketClientTwoWayPing:              func() Packet { return &TwoWayPing{} },
+})
+
+// Packets sent by servers
+var ServerPackets = newPacketTable(map[byte]func() Packet{
+	PacketServerHello:                     func() Packet { return &ServerHello{} },
+	PacketServerPing:                      func() Packet { return &Ping{} },
+	PacketServerLevelInit:                 func() Packet { return &LevelInit{} },
+	PacketServerLevelDataChunk:            func() Packet { return &LevelDataChunk{} },
+	PacketServerLevelFinalize:             func() Packet { return &LevelFinalize{} },
+	PacketServerSetBlock:                  func() Packet { return &ServerSetBlock{} },
+	PacketServerSpawnPlayer:               func() Packet { return &SpawnPlayer{} },
+	PacketServerTeleportPlayer:            func() Packet { return &SetPosition{} },
+	PacketServerPositionOrientationUpdate: func() Packet { return &PositionOrientationUpdate{} },
+	PacketServerPositionUpdate:            func() Packet { return &PositionUpdate{} },
+	PacketServerOrientationUpdate:         func() Packet { return &OrientationUpdate{} },
+	PacketServerDespawnPlayer:             func() Packet { return &DespawnPlayer{} },
+	PacketServerMessage:                   func() Packet { return &Message{} },
+	PacketServerKick:                      func() Packet { return &Kick{} },
+	PacketServerUpdateUserType:            func() Packet { return &UpdateUserType{} },
+	PacketServerExtInfo:                   func() Packet { return &ExtInfo{} },
+	PacketServerExtEntry:                  func() Packet { return &ExtEntry{} },
+	PacketServerSetClickDistance:          func() Packet { return &SetClickDistance{} },
+	PacketServerCustomBlockSupportLevel:   func() Packet { return &CustomBlockSupportLevel{} },
+	PacketServerHoldThis:                  func() Packet { return &HoldThis{} },
+	PacketServerSetTextHotKey:             func() Packet { return &SetTextHotKey{} },
+	PacketServerExtAddPlayerName:          func() Packet { return &ExtAddPlayerName{} },
+	PacketServerExtRemovePlayerName:       func() Packet { return &ExtRemovePlayerName{} },
+	PacketServerEnvSetColor:               func() Packet { return &EnvSetColor{} },
+	PacketServerMakeSelection:             func() Packet { return &MakeSelection{} },
+	PacketServerRemoveSelection:           func() Packet { return &RemoveSelection{} },
+	PacketServerSetBlockPermission:        func() Packet { return &SetBlockPermission{} },
+	PacketServerChangeModel:               func() Packet { return &ChangeModel{} },
+	PacketServerEnvSetMapAppearance:       func() Packet { return &EnvSetMapAppearance{} },
+	PacketServerEnvSetWeatherType:         func() Packet { return &EnvSetWeatherType{} },
+	PacketServerHackControl:               func() Packet { return &HackControl{} },
+	PacketServerExtAddEntity2:             func() Packet { return &ExtAddEntity2{} },
+	PacketServerTwoWayPing:                func() Packet { return &TwoWayPing{} },
+})
+
+// Packets sent by servers once FastMap has been negotiated, which changes
+// the size of LevelInit
+var ServerPacketsFastMap = ServerPackets.with(PacketServerLevelInit, func() Packet { return &LevelInitFastMap{} })
+
+func (t PacketTable) with(id byte, newPacket func() Packet) PacketTable {
+	table := make(PacketTable)
+	for k, v := range t {
+		table[k] = v
+	}
+	table[id] = packetType{newPacket().Size(), newPacket}
+
+	return table
+}
+
+// Size returns the size of a packet with the given ID, not counting the ID.
+func (t PacketTable) Size(id byte) (int, bool) {
+	pt, ok := t[id]
+	return pt.size, ok
+}
+
+// EncodePacket returns the packet prefixed with its ID, as sent on the wire.
+func EncodePacket(p Packet) ([]byte, error) {
+	buf := make([]byte, 1+p.Size())
+	if err := new(PacketBuffer).encode(buf, p); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// DecodePacket decodes a whole packet, including its ID.
+func DecodePacket(t PacketTable, data []byte) (Packet, error) {
+	if len(data) == 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	pt, ok := t[data[0]]
+	if !ok {
+		return nil, fmt.Errorf("invalid packet ID 0x%02x", data[0])
+	}
+	if len(data) != 1+pt.size {
+		return nil, fmt.Errorf("packet 0x%02x has wrong size %d", data[0], len(data))
+	}
+
+	p := pt.newPacket()
+
+	b := &PacketBuffer{buf: data[1:]}
+	p.Decode(b)
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	return p, nil
+}
+
+// ReadPacket reads the next packet in full.  io.EOF is only returned if the
+// stream ends between packets.
+func ReadPacket(r *bufio.Reader, t PacketTable) (Packet, error) {
+	id, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	size, ok := t.Size(id)
+	if !ok {
+		return nil, fmt.Errorf("invalid packet ID 0x%02x", id)
+	}
+
+	data := make([]byte, 1+size)
+	data[0] = id
+	if _, err := io.ReadFull(r, data[1:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return DecodePacket(t, data)
+}
+
+// PacketBuffer reads or writes the fields of a packet in order.  The first
+// error is kept and reported once the whole packet has been processed.
+type PacketBuffer struct {
+	buf []byte
+	pos int
+	err error
+}
+
+// encode writes p, prefixed with its ID, to buf, which must be exactly the
+// right size.  b is reset and used to do so, which lets callers that send a
+// lot of packets avoid allocating.
+func (b *PacketBuffer) encode(buf []byte, p Packet) error {
+	buf[0] = p.ID()
+	*b = PacketBuffer{buf: buf[1:]}
+	p.Encode(b)
+
+	return b.err
+}
+
+func (b *PacketBuffer) next(n int) []byte {
+	if b.err != nil {
+		return make([]byte, n)
+	}
+	if b.pos+n > len(b.buf) {
+		b.err = errors.New("packet field out of bounds")
+		return make([]byte, n)
+	}
+
+	field := b.buf[b.pos : b.pos+n]
+	b.pos += n
+	return field
+}
+
+func (b *PacketBuffer) putByte(v byte) {
+	b.next(1)[0] = v
+}
+
+func (b *PacketBuffer) putInt16(v int16) {
+	writeInt16(b.next(2), v)
+}
+
+func (b *PacketBuffer) putInt32(v int32) {
+	field := b.next(4)
+	field[0] = byte(v >> 24)
+	field[1] = byte(v >> 16)
+	field[2] = byte(v >> 8)
+	field[3] = byte(v)
+}
+
+func (b *PacketBuffer) putString(s string) {
+	if err := writeString(b.next(64), s); err != nil && b.err == nil {
+		b.err = err
+	}
+}
+
+func (b *PacketBuffer) putBytes(data []byte, size int) {
+	if len(data) > size && b.err == nil {
+		b.err = errors.New("packet field too long")
+	}
+
+	field := b.next(size)
+	n := copy(field, data)
+	for i := n; i < size; i++ {
+		field[i] = 0
+	}
+}
+
+func (b *PacketBuffer) getByte() byte {
+	return b.next(1)[0]
+}
+
+func (b *PacketBuffer) getInt16() int16 {
+	field := b.next(2)
+	return int16(field[0])<<8 | int16(field[1])
+}
+
+func (b *PacketBuffer) getInt32() int32 {
+	field := b.next(4)
+	return int32(field[0])<<24 | int32(field[1])<<16 | int32(field[2])<<8 | int32(field[3])
+}
+
+func (b *PacketBuffer) getString() string {
+	return strings.TrimRight(string(b.next(64)), " ")
+}
+
+func (b *PacketBuffer) getBytes(size int) []byte {
+	data := make([]byte, size)
+	copy(data, b.next(size))
+	return data
+}
+
+type ClientHello struct {
+	ProtocolVersion byte
+	Name            string
+	Mppass          string
+	// Unused in vanilla Classic, but used by clients to signal CPE support
+	Unused byte
+}
+
+func (p *ClientHello) ID() byte  { return PacketClientHello }
+func (p *ClientHello) Size() int { return 130 }
+
+func (p *ClientHello) Encode(b *PacketBuffer) {
+	b.putByte(p.ProtocolVersion)
+	b.putString(p.Name)
+	b.putString(p.Mppass)
+	b.putByte(p.Unused)
+}
+
+func (p *ClientHello) Decode(b *PacketBuffer) {
+	p.ProtocolVersion = b.getByte()
+	p.Name = b.getString()
+	p.Mppass = b.getString()
+	p.Unused = b.getByte()
+}
+
+func (p *ClientHello) SupportsCPE() bool {
+	return p.Unused == ClientHelloCPEMagic
+}
+
+type ServerHello struct {
+	ProtocolVersion byte
+	Name            string
+	MOTD            string
+	UserType        PlayerType
+}
+
+func (p *ServerHello) ID() byte  { return PacketServerHello }
+func (p *ServerHello) Size() int { return 130 }
+
+func (p *ServerHello) Encode(b *PacketBuffer) {
+	b.putByte(p.ProtocolVersion)
+	b.putString(p.Name)
+	b.putString(p.MOTD)
+	b.putByte(byte(p.UserType))
+}
+
+func (p *ServerHello) Decode(b *PacketBuffer) {
+	p.ProtocolVersion = b.getByte()
+	p.Name = b.getString()
+	p.MOTD = b.getString()
+	p.UserType = PlayerType(b.getByte())
+}
+
+type Ping struct{}
+
+func (p *Ping) ID() byte               { return PacketServerPing }
+func (p *Ping) Size() int              { return 0 }
+func (p *Ping) Encode(b *PacketBuffer) {}
+func (p *Ping) Decode(b *PacketBuffer) {}
+
+type LevelInit struct{}
+
+func (p *LevelInit) ID() byte               { return PacketServerLevelInit }
+func (p *LevelInit) Size() int              { return 0 }
+func (p *LevelInit) Encode(b *PacketBuffer) {}
+func (p *LevelInit) Decode(b *PacketBuffer) {}
+
+// LevelInitFastMap announces the level volume, as required by the FastMap
+// extension.  The level data that follows is raw DEFLATE, without the length
+// prefix.
+type LevelInitFastMap struct {
+	Volume int32
+}
+
+func (p *LevelInitFastMap) ID() byte  { return PacketServerLevelInit }
+func (p *LevelInitFastMap) Size() int { return 4 }
+
+func (p *LevelInitFastMap) Encode(b *PacketBuffer) {
+	b.putInt32(p.Volume)
+}
+
+func (p *LevelInitFastMap) Decode(b *PacketBuffer) {
+	p.Volume = b.getInt32()
+}
+
+const levelChunkSize = 1024
+
+type LevelDataChunk struct {
+	// Up to 1024 bytes; padded with zeroes when encoded
+	Data            []byte
+	PercentComplete byte
+}
+
+func (p *LevelDataChunk) ID() byte  { return PacketServerLevelDataChunk }
+func (p *LevelDataChunk) Size() int { return 2 + levelChunkSize + 1 }
+
+func (p *LevelDataChunk) Encode(b *PacketBuffer) {
+	b.putInt16(int16(len(p.Data)))
+	b.putBytes(p.Data, levelChunkSize)
+	b.putByte(p.PercentComplete)
+}
+
+func (p *LevelDataChunk) Decode(b *PacketBuffer) {
+	length := int(b.getInt16())
+	data := b.getBytes(levelChunkSize)
+	if length < 0 || length > levelChunkSize {
+		if b.err == nil {
+			b.err = errors.New("invalid level data chunk length")
+		}
+		length = 0
+	}
+	p.Data = data[:length]
+	p.PercentComplete = b.getByte()
+}
+
+type LevelFinalize struct {
+	Width, Depth, Height int16
+}
+
+func (p *LevelFinalize) ID() byte  { return PacketServerLevelFinalize }
+func (p *LevelFinalize) Size() int { return 6 }
+
+func (p *LevelFinalize) Encode(b *PacketBuffer) {
+	b.putInt16(p.Width)
+	b.putInt16(p.Depth)
+	b.putInt16(p.Height)
+}
+
+func (p *LevelFinalize) Decode(b *PacketBuffer) {
+	p.Width = b.getInt16()
+	p.Depth = b.getInt16()
+	p.Height = b.getInt16()
+}
+
+type ClientSetBlock struct {
+	X, Y, Z int16
+	// 0 when destroying a block, 1 when creating one
+	Mode      byte
+	BlockType byte
+}
+
+func (p *ClientSetBlock) ID() byte  { return PacketClientSetBlock }
+func (p *ClientSetBlock) Size() int { return 8 }
+
+func (p *ClientSetBlock) Encode(b *PacketBuffer) {
+	b.putInt16(p.X)
+	b.putInt16(p.Y)
+	b.putInt16(p.Z)
+	b.putByte(p.Mode)
+	b.putByte(p.BlockType)
+}
+
+func (p *ClientSetBlock) Decode(b *PacketBuffer) {
+	p.X = b.getInt16()
+	p.Y = b.getInt16()
+	p.Z = b.getInt16()
+	p.Mode = b.getByte()
+	p.BlockType = b.getByte()
+}
+
+type ServerSetBlock struct {
+	X, Y, Z   int16
+	BlockType byte
+}
+
+func (p *ServerSetBlock) ID() byte  { return PacketServerSetBlock }
+func (p *ServerSetBlock) Size() int { return 7 }
+
+func (p *ServerSetBlock) Encode(b *PacketBuffer) {
+	b.putInt16(p.X)
+	b.putInt16(p.Y)
+	b.putInt16(p.Z)
+	b.putByte(p.BlockType)
+}
+
+func (p *ServerSetBlock) Decode(b *PacketBuffer) {
+	p.X = b.getInt16()
+	p.Y = b.getInt16()
+	p.Z = b.getInt16()
+	p.BlockType = b.getByte()
+}
+
+type SpawnPlayer struct {
+	PlayerID   int8
+	Name       string
+	X, Y, Z    int16
+	Yaw, Pitch byte
+}
+
+func (p *SpawnPlayer) ID() byte  { return PacketServerSpawnPlayer }
+func (p *SpawnPlayer) Size() int { return 73 }
+
+func (p *SpawnPlayer) Encode(b *PacketBuffer) {
+	b.putByte(byte(p.PlayerID))
+	b.putString(p.Name)
+	b.putInt16(p.X)
+	b.putInt16(p.Y)
+	b.putInt16(p.Z)
+	b.putByte(p.Yaw)
+	b.putByte(p.Pitch)
+}
+
+func (p *SpawnPlayer) Decode(b *PacketBuffer) {
+	p.PlayerID = int8(b.getByte())
+	p.Name = b.getString()
+	p.X = b.getInt16()
+	p.Y = b.getInt16()
+	p.Z = b.getInt16()
+	p.Yaw = b.getByte()
+	p.Pitch = b.getByte()
+}
+
+// SetPosition is sent by clients to report their own position (with a
+// PlayerID of -1), and by servers to teleport a player.
+type SetPosition struct {
+	PlayerID   int8
+	X, Y, Z    int16
+	Yaw, Pitch byte
+}
+
+func (p *SetPosition) ID() byte  { return PacketServerTeleportPlayer }
+func (p *SetPosition) Size() int { return 9 }
+
+func (p *SetPosition) Encode(b *PacketBuffer) {
+	b.putByte(byte(p.PlayerID))
+	b.putInt16(p.X)
+	b.putInt16(p.Y)
+	b.putInt16(p.Z)
+	b.putByte(p.Yaw)
+	b.putByte(p.Pitch)
+}
+
+func (p *SetPosition) Decode(b *PacketBuffer) {
+	p.PlayerID = int8(b.getByte())
+	p.X = b.getInt16()
+	p.Y = b.getInt16()
+	p.Z = b.getInt16()
+	p.Yaw = b.getByte()
+	p.Pitch = b.getByte()
+}
+
+type PositionOrientationUpdate struct {
+	PlayerID   int8
+	DX, DY, DZ int8
+	Yaw, Pitch byte
+}
+
+func (p *PositionOrientationUpdate) ID() byte  { return PacketServerPositionOrientationUpdate }
+func (p *PositionOrientationUpdate) Size() int { return 6 }
+
+func (p *PositionOrientationUpdate) Encode(b *PacketBuffer) {
+	b.putByte(byte(p.PlayerID))
+	b.putByte(byte(p.DX))
+	b.putByte(byte(p.DY))
+	b.putByte(byte(p.DZ))
+	b.putByte(p.Yaw)
+	b.putByte(p.Pitch)
+}
+
+func (p *PositionOrientationUpdate) Decode(b *PacketBuffer) {
+	p.PlayerID = int8(b.getByte())
+	p.DX = int8(b.getByte())
+	p.DY = int8(b.getByte())
+	p.DZ = int8(b.getByte())
+	p.Yaw = b.getByte()
+	p.Pitch = b.getByte()
+}
+
+type PositionUpdate struct {
+	PlayerID   int8
+	DX, DY, DZ int8
+}
+
+func (p *PositionUpdate) ID() byte  { return PacketServerPositionUpdate }
+func (p *PositionUpdate) Size() int { return 4 }
+
+func (p *PositionUpdate) Encode(b *PacketBuffer) {
+	b.putByte(byte(p.PlayerID))
+	b.putByte(byte(p.DX))
+	b.putByte(byte(p.DY))
+	b.putByte(byte(p.DZ))
+}
+
+func (p *PositionUpdate) Decode(b *PacketBuffer) {
+	p.PlayerID = int8(b.getByte())
+	p.DX = int8(b.getByte())
+	p.DY = int8(b.getByte())
+	p.DZ = int8(b.getByte())
+}
+
+type OrientationUpdate struct {
+	PlayerID   int8
+	Yaw, Pitch byte
+}
+
+func (p *OrientationUpdate) ID() byte  { return PacketServerOrientationUpdate }
+func (p *OrientationUpdate) Size() int { return 3 }
+
+func (p *OrientationUpdate) Encode(b *PacketBuffer) {
+	b.putByte(byte(p.PlayerID))
+	b.putByte(p.Yaw)
+	b.putByte(p.Pitch)
+}
+
+func (p *OrientationUpdate) Decode(b *PacketBuffer) {
+	p.PlayerID = int8(b.getByte())
+	p.Yaw = b.getByte()
+	p.Pitch = b.getByte()
+}
+
+type DespawnPlayer struct {
+	PlayerID int8
+}
+
+func (p *DespawnPlayer) ID() byte  { return PacketServerDespawnPlayer }
+func (p *DespawnPlayer) Size() int { return 1 }
+
+func (p *DespawnPlayer) Encode(b *PacketBuffer) {
+	b.putByte(byte(p.PlayerID))
+}
+
+func (p *DespawnPlayer) Decode(b *PacketBuffer) {
+	p.PlayerID = int8(b.getByte())
+}
+
+// Message is a chat message.  Clients always send a PlayerID of -1.
+type Message struct {
+	PlayerID int8
+	Message  string
+}
+
+func (p *Message) ID() byte  { return PacketServerMessage }
+func (p *Message) Size() int { return 65 }
+
+func (p *Message) Encode(b *PacketBuffer) {
+	b.putByte(byte(p.PlayerID))
+	b.putString(p.Message)
+}
+
+func (p *Message) Decode(b *PacketBuffer) {
+	p.PlayerID = int8(b.getByte())
+	p.Message = b.getString()
+}
+
+type Kick struct {
+	Reason string
+}
+
+func (p *Kick) ID() byte  { return PacketServerKick }
+func (p *Kick) Size() int { return 64 }
+
+func (p *Kick) Encode(b *PacketBuffer) {
+	b.putString(p.Reason)
+}
+
+func (p *Kick) Decode(b *PacketBuffer) {
+	p.Reason = b.getString()
+}
+
+type UpdateUserType struct {
+	UserType PlayerType
+}
+
+func (p *UpdateUserType) ID() byte  { return PacketServerUpdateUserType }
+func (p *UpdateUserType) Size() int { return 1 }
+
+func (p *UpdateUserType) Encode(b *PacketBuffer) {
+	b.putByte(byte(p.UserType))
+}
+
+func (p *UpdateUserType) Decode(b *PacketBuffer) {
+	p.UserType = PlayerType(b.getByte())
+}
+
+type ExtInfo struct {
+	AppName        string
+	ExtensionCount int16
+}
+
+func (p *ExtInfo) ID() byte  { return PacketServerExtInfo }
+func (p *ExtInfo) Size() int { return 66 }
+
+func (p *ExtInfo) Encode(b *PacketBuffer) {
+	b.putString(p.AppName)
+	b.putInt16(p.ExtensionCount)
+}
+
+func (p *ExtInfo) Decode(b *PacketBuffer) {
+	p.AppName = b.getString()
+	p.ExtensionCount = b.getInt16()
+}
+
+type ExtEntry struct {
+	ExtName string
+	Version int32
+}
+
+func (p *ExtEntry) ID() byte  { return PacketServerExtEntry }
+func (p *ExtEntry) Size() int { return 68 }
+
+func (p *ExtEntry) Encode(b *PacketBuffer) {
+	b.putString(p.ExtName)
+	b.putInt32(p.Version)
+}
+
+func (p *ExtEntry) Decode(b *PacketBuffer) {
+	p.ExtName = b.getString()
+	p.Version = b.getInt32()
+}
+
+// EnvSetColor changes one of the colors of the sky, clouds, fog or lighting
+// (EnvColors extension).  A component of -1 resets the color to the
+// client's default.
+type EnvSetColor struct {
+	Variable         byte
+	Red, Green, Blue int16
+}
+
+func (p *EnvSetColor) ID() byte  { return PacketServerEnvSetColor }
+func (p *EnvSetColor) Size() int { return 7 }
+
+func (p *EnvSetColor) Encode(b *PacketBuffer) {
+	b.putByte(p.Variable)
+	b.putInt16(p.Red)
+	b.putInt16(p.Green)
+	b.putInt16(p.Blue)
+}
+
+func (p *EnvSetColor) Decode(b *PacketBuffer) {
+	p.Variable = b.getByte()
+	p.Red = b.getInt16()
+	p.Green = b.getInt16()
+	p.Blue = b.getInt16()
+}
+
+// CustomBlockSupportLevel is sent by both sides to agree on which custom
+// blocks the client can show (CustomBlocks extension).
+type CustomBlockSupportLevel struct {
+	SupportLevel byte
+}
+
+func (p *CustomBlockSupportLevel) ID() byte  { return PacketServerCustomBlockSupportLevel }
+func (p *CustomBlockSupportLevel) Size() int { return 1 }
+
+func (p *CustomBlockSupportLevel) Encode(b *PacketBuffer) {
+	b.putByte(p.SupportLevel)
+}
+
+func (p *CustomBlockSupportLevel) Decode(b *PacketBuffer) {
+	p.SupportLevel = b.getByte()
+}
+
+// PlayerClicked reports a mouse click, and what was being looked at
+// (PlayerClick extension).
+type PlayerClicked struct {
+	Button                                   byte
+	Action                                   byte
+	Yaw, Pitch                               int16
+	TargetEntityID                           int8
+	TargetBlockX, TargetBlockY, TargetBlockZ int16
+	TargetBlockFace                          byte
+}
+
+func (p *PlayerClicked) ID() byte  { return PacketClientPlayerClicked }
+func (p *PlayerClicked) Size() int { return 14 }
+
+func (p *PlayerClicked) Encode(b *PacketBuffer) {
+	b.putByte(p.Button)
+	b.putByte(p.Action)
+	b.putInt16(p.Yaw)
+	b.putInt16(p.Pitch)
+	b.putByte(byte(p.TargetEntityID))
+	b.putInt16(p.TargetBlockX)
+	b.putInt16(p.TargetBlockY)
+	b.putInt16(p.TargetBlockZ)
+	b.putByte(p.TargetBlockFace)
+}
+
+func (p *PlayerClicked) Decode(b *PacketBuffer) {
+	p.Button = b.getByte()
+	p.Action = b.getByte()
+	p.Yaw = b.getInt16()
+	p.Pitch = b.getInt16()
+	p.TargetEntityID = int8(b.getByte())
+	p.TargetBlockX = b.getInt16()
+	p.TargetBlockY = b.getInt16()
+	p.TargetBlockZ = b.getInt16()
+	p.TargetBlockFace = b.getByte()
+}
+
+// TwoWayPing is sent by either side and echoed back by the other, to measure
+// latency (TwoWayPing extension).  Direction is 0 for pings started by the
+// client and 1 for those started by the server.
+type TwoWayPing struct {
+	Direction byte
+	Data      int16
+}
+
+func (p *TwoWayPing) ID() byte  { return PacketServerTwoWayPing }
+func (p *TwoWayPing) Size() int { return 3 }
+
+func (p *TwoWayPing) Encode(b *PacketBuffer) {
+	b.putByte(p.Direction)
+	b.putInt16(p.Data)
+}
+
+func (p *TwoWayPing) Decode(b *PacketBuffer) {
+	p.Direction = b.getByte()
+	p.Data = b.getInt16()
+}
+
+// SetClickDistance limits how far away the player can reach, in player
+// units (ClickDistance extension).
+type SetClickDistance struct {
+	Distance int16
+}
+
+func (p *SetClickDistance) ID() byte  { return PacketServerSetClickDistance }
+func (p *SetClickDistance) Size() int { return 2 }
+
+func (p *SetClickDistance) Encode(b *PacketBuffer) {
+	b.putInt16(p.Distance)
+}
+
+func (p *SetClickDistance) Decode(b *PacketBuffer) {
+	p.Distance = b.getInt16()
+}
+
+// HoldThis changes the block in the player's hand (HeldBlock extension).
+type HoldThis struct {
+	BlockToHold   byte
+	PreventChange byte
+}
+
+func (p *HoldThis) ID() byte  { return PacketServerHoldThis }
+func (p *HoldThis) Size() int { return 2 }
+
+func (p *HoldThis) Encode(b *PacketBuffer) {
+	b.putByte(p.BlockToHold)
+	b.putByte(p.PreventChange)
+}
+
+func (p *HoldThis) Decode(b *PacketBuffer) {
+	p.BlockToHold = b.getByte()
+	p.PreventChange = b.getByte()
+}
+
+// SetTextHotKey binds a key to a chat message or command (TextHotKey
+// extension).
+type SetTextHotKey struct {
+	Label   string
+	Action  string
+	KeyCode int32
+	KeyMods byte
+}
+
+func (p *SetTextHotKey) ID() byte  { return PacketServerSetTextHotKey }
+func (p *SetTextHotKey) Size() int { return 133 }
+
+func (p *SetTextHotKey) Encode(b *PacketBuffer) {
+	b.putString(p.Label)
+	b.putString(p.Action)
+	b.putInt32(p.KeyCode)
+	b.putByte(p.KeyMods)
+}
+
+func (p *SetTextHotKey) Decode(b *PacketBuffer) {
+	p.Label = b.getString()
+	p.Action = b.getString()
+	p.KeyCode = b.getInt32()
+	p.KeyMods = b.getByte()
+}
+
+// ExtAddPlayerName adds or updates an entry in the player list
+// (ExtPlayerList extension).
+type ExtAddPlayerName struct {
+	NameID     int16
+	PlayerName string
+	ListName   string
+	GroupName  string
+	GroupRank  byte
+}
+
+func (p *ExtAddPlayerName) ID() byte  { return PacketServerExtAddPlayerName }
+func (p *ExtAddPlayerName) Size() int { return 195 }
+
+func (p *ExtAddPlayerName) Encode(b *PacketBuffer) {
+	b.putInt16(p.NameID)
+	b.putString(p.PlayerName)
+	b.putString(p.ListName)
+	b.putString(p.GroupName)
+	b.putByte(p.GroupRank)
+}
+
+func (p *ExtAddPlayerName) Decode(b *PacketBuffer) {
+	p.NameID = b.getInt16()
+	p.PlayerName = b.getString()
+	p.ListName = b.getString()
+	p.GroupName = b.getString()
+	p.GroupRank = b.getByte()
+}
+
+// ExtRemovePlayerName removes an entry from the player list (ExtPlayerList
+// extension).
+type ExtRemovePlayerName struct {
+	NameID int16
+}
+
+func (p *ExtRemovePlayerName) ID() byte  { return PacketServerExtRemovePlayerName }
+func (p *ExtRemovePlayerName) Size() int { return 2 }
+
+func (p *ExtRemovePlayerName) Encode(b *PacketBuffer) {
+	b.putInt16(p.NameID)
+}
+
+func (p *ExtRemovePlayerName) Decode(b *PacketBuffer) {
+	p.NameID = b.getInt16()
+}
+
+// MakeSelection highlights a cuboid (SelectionCuboid extension).  Colors
+// and opacity range from 0 to 255.
+type MakeSelection struct {
+	SelectionID               byte
+	Label                     string
+	StartX, StartY, StartZ    int16
+	EndX, EndY, EndZ          int16
+	Red, Green, Blue, Opacity int16
+}
+
+func (p *MakeSelection) ID() byte  { return PacketServerMakeSelection }
+func (p *MakeSelection) Size() int { return 85 }
+
+func (p *MakeSelection) Encode(b *PacketBuffer) {
+	b.putByte(p.SelectionID)
+	b.putString(p.Label)
+	b.putInt16(p.StartX)
+	b.putInt16(p.StartY)
+	b.putInt16(p.StartZ)
+	b.putInt16(p.EndX)
+	b.putInt16(p.EndY)
+	b.putInt16(p.EndZ)
+	b.putInt16(p.Red)
+	b.putInt16(p.Green)
+	b.putInt16(p.Blue)
+	b.putInt16(p.Opacity)
+}
+
+func (p *MakeSelection) Decode(b *PacketBuffer) {
+	p.SelectionID = b.getByte()
+	p.Label = b.getString()
+	p.StartX = b.getInt16()
+	p.StartY = b.getInt16()
+	p.StartZ = b.getInt16()
+	p.EndX = b.getInt16()
+	p.EndY = b.getInt16()
+	p.EndZ = b.getInt16()
+	p.Red = b.getInt16()
+	p.Green = b.getInt16()
+	p.Blue = b.getInt16()
+	p.Opacity = b.getInt16()
+}
+
+// RemoveSelection removes a cuboid added by MakeSelection.
+type RemoveSelection struct {
+	SelectionID byte
+}
+
+func (p *RemoveSelection) ID() byte  { return PacketServerRemoveSelection }
+func (p *RemoveSelection) Size() int { return 1 }
+
+func (p *RemoveSelection) Encode(b *PacketBuffer) {
+	b.putByte(p.SelectionID)
+}
+
+func (p *RemoveSelection) Decode(b *PacketBuffer) {
+	p.SelectionID = b.getByte()
+}
+
+// SetBlockPermission controls whether a block can be placed or deleted
+// (BlockPermissions extension).
+type SetBlockPermission struct {
+	BlockType      byte
+	AllowPlacement byte
+	AllowDeletion  byte
+}
+
+func (p *SetBlockPermission) ID() byte  { return PacketServerSetBlockPermission }
+func (p *SetBlockPermission) Size() int { return 3 }
+
+func (p *SetBlockPermission) Encode(b *PacketBuffer) {
+	b.putByte(p.BlockType)
+	b.putByte(p.AllowPlacement)
+	b.putByte(p.AllowDeletion)
+}
+
+func (p *SetBlockPermission) Decode(b *PacketBuffer) {
+	p.BlockType = b.getByte()
+	p.AllowPlacement = b.getByte()
+	p.AllowDeletion = b.getByte()
+}
+
+// ChangeModel changes the model a player or other entity is drawn with
+// (ChangeModel extension).
+type ChangeModel struct {
+	EntityID  int8
+	ModelName string
+}
+
+func (p *ChangeModel) ID() byte  { return PacketServerChangeModel }
+func (p *ChangeModel) Size() int { return 65 }
+
+func (p *ChangeModel) Encode(b *PacketBuffer) {
+	b.putByte(byte(p.EntityID))
+	b.putString(p.ModelName)
+}
+
+func (p *ChangeModel) Decode(b *PacketBuffer) {
+	p.EntityID = int8(b.getByte())
+	p.ModelName = b.getString()
+}
+
+// EnvSetMapAppearance sets the texture pack and the blocks around the edge
+// of the level.  This is version 2 of the EnvMapAppearance extension, which
+// added the cloud height and fog distance.
+type EnvSetMapAppearance struct {
+	TextureURL      string
+	SideBlock       byte
+	EdgeBlock       byte
+	SideLevel       int16
+	CloudsLevel     int16
+	MaxViewDistance int16
+}
+
+func (p *EnvSetMapAppearance) ID() byte  { return PacketServerEnvSetMapAppearance }
+func (p *EnvSetMapAppearance) Size() int { return 72 }
+
+func (p *EnvSetMapAppearance) Encode(b *PacketBuffer) {
+	b.putString(p.TextureURL)
+	b.putByte(p.SideBlock)
+	b.putByte(p.EdgeBlock)
+	b.putInt16(p.SideLevel)
+	b.putInt16(p.CloudsLevel)
+	b.putInt16(p.MaxViewDistance)
+}
+
+func (p *EnvSetMapAppearance) Decode(b *PacketBuffer) {
+	p.TextureURL = b.getString()
+	p.SideBlock = b.getByte()
+	p.EdgeBlock = b.getByte()
+	p.SideLevel = b.getInt16()
+	p.CloudsLevel = b.getInt16()
+	p.MaxViewDistance = b.getInt16()
+}
+
+// EnvSetWeatherType sets the weather: 0 for sun, 1 for rain and 2 for snow
+// (EnvWeatherType extension).
+type EnvSetWeatherType struct {
+	WeatherType byte
+}
+
+func (p *EnvSetWeatherType) ID() byte  { return PacketServerEnvSetWeatherType }
+func (p *EnvSetWeatherType) Size() int { return 1 }
+
+func (p *EnvSetWeatherType) Encode(b *PacketBuffer) {
+	b.putByte(p.WeatherType)
+}
+
+func (p *EnvSetWeatherType) Decode(b *PacketBuffer) {
+	p.WeatherType = b.getByte()
+}
+
+// HackControl allows or forbids client movement hacks (HackControl
+// extension).  A JumpHeight of -1 leaves the client's default.
+type HackControl struct {
+	Flying          byte
+	NoClip          byte
+	Speeding        byte
+	SpawnControl    byte
+	ThirdPersonView byte
+	JumpHeight      int16
+}
+
+func (p *HackControl) ID() byte  { return PacketServerHackControl }
+func (p *HackControl) Size() int { return 7 }
+
+func (p *HackControl) Encode(b *PacketBuffer) {
+	b.putByte(p.Flying)
+	b.putByte(p.NoClip)
+	b.putByte(p.Speeding)
+	b.putByte(p.SpawnControl)
+	b.putByte(p.ThirdPersonView)
+	b.putInt16(p.JumpHeight)
+}
+
+func (p *HackControl) Decode(b *PacketBuffer) {
+	p.Flying = b.getByte()
+	p.NoClip = b.getByte()
+	p.Speeding = b.getByte()
+	p.SpawnControl = b.getByte()
+	p.ThirdPersonView = b.getByte()
+	p.JumpHeight = b.getInt16()
+}
+
+// ExtAddEntity2 spawns an entity with a skin and a name separate from the
+// one in the player list (ExtPlayerList extension, version 2).
+type ExtAddEntity2 struct {
+	EntityID   int8
+	InGameName string
+	SkinName   string
+	X, Y, Z    int16
+	Yaw, Pitch byte
+}
+
+func (p *ExtAddEntity2) ID() byte  { return PacketServerExtAddEntity2 }
+func (p *ExtAddEntity2) Size() int { return 137 }
+
+func (p *ExtAddEntity2) Encode(b *PacketBuffer) {
+	b.putByte(byte(p.EntityID))
+	b.putString(p.InGameName)
+	b.putString(p.SkinName)
+	b.putInt16(p.X)
+	b.putInt16(p.Y)
+	b.putInt16(p.Z)
+	b.putByte(p.Yaw)
+	b.putByte(p.Pitch)
+}
+
+func (p *ExtAddEntity2) Decode(b *PacketBuffer) {
+	p.EntityID = int8(b.getByte())
+	p.InGameName = b.getString()
+	p.SkinName = b.getString()
+	p.X = b.getInt16()
+	p.Y = b.getInt16()
+	p.Z = b.getInt16()
+	p.Yaw = b.getByte()
+	p.Pitch = b.getByte()
+}
@@ -1,9 +1,9 @@
 package main
 
 import (
+	"bufio"
 	"errors"
 	"io"
-	"strings"
 )
 
 type PlayerType byte
@@ -17,281 +17,203 @@ const (
 	ProtocolVersionClassic30 = 0x07
 )
 
+// Sent in place of the unused byte in ClientHello by clients that support the
+// Classic Protocol Extension
+const ClientHelloCPEMagic = 0x42
+
 const (
 	PacketClientHello          = 0x00
 	PacketClientSetBlock       = 0x05
 	PacketClientPositionUpdate = 0x08
 	PacketClientMessage        = 0x0d
+	PacketClientExtInfo        = 0x10
+	PacketClientExtEntry       = 0x11
+	// Only sent once the matching extension has been negotiated
+	PacketClientCustomBlockSupportLevel = 0x13
+	PacketClientPlayerClicked           = 0x22
+	PacketClientTwoWayPing              = 0x2b
 )
 
 const (
-	PacketServerHello          = 0x00
-	PacketServerLevelInit      = 0x02
-	PacketServerLevelDataChunk = 0x03
-	PacketServerLevelFinalize  = 0x04
-	PacketServerSpawnPlayer    = 0x07
-	PacketServerMessage        = 0x0d
-	PacketServerKick           = 0x0e
+	PacketServerHello                     = 0x00
+	PacketServerPing                      = 0x01
+	PacketServerLevelInit                 = 0x02
+	PacketServerLevelDataChunk            = 0x03
+	PacketServerLevelFinalize             = 0x04
+	PacketServerSetBlock                  = 0x06
+	PacketServerSpawnPlayer               = 0x07
+	PacketServerTeleportPlayer            = 0x08
+	PacketServerPositionOrientationUpdate = 0x09
+	PacketServerPositionUpdate            = 0x0a
+	PacketServerOrientationUpdate         = 0x0b
+	PacketServerDespawnPlayer             = 0x0c
+	PacketServerMessage                   = 0x0d
+	PacketServerKick                      = 0x0e
+	PacketServerUpdateUserType            = 0x0f
+	PacketServerExtInfo                   = 0x10
+	PacketServerExtEntry                  = 0x11
+	PacketServerSetClickDistance          = 0x12
+	PacketServerCustomBlockSupportLevel   = 0x13
+	PacketServerHoldThis                  = 0x14
+	PacketServerSetTextHotKey             = 0x15
+	PacketServerExtAddPlayerName          = 0x16
+	PacketServerExtRemovePlayerName       = 0x18
+	PacketServerEnvSetColor               = 0x19
+	PacketServerMakeSelection             = 0x1a
+	PacketServerRemoveSelection           = 0x1b
+	PacketServerSetBlockPermission        = 0x1c
+	PacketServerChangeModel               = 0x1d
+	PacketServerEnvSetMapAppearance       = 0x1e
+	PacketServerEnvSetWeatherType         = 0x1f
+	PacketServerHackControl               = 0x20
+	PacketServerExtAddEntity2             = 0x21
+	PacketServerTwoWayPing                = 0x2b
 )
 
 const (
 	MessageSenderServer int8 = -1
 )
 
+// Packets are buffered until Flush is called, so that e.g. a whole level can
+// be sent with a handful of writes.
+const serverEncoderBufferSize = 16 << 10
+
 type ServerEncoder struct {
-	w io.Writer
+	w *bufio.Writer
+	// Scratch space for encoding
+	buf []byte
+	pb  PacketBuffer
+
+	// Reused for every packet, so that sending them doesn't allocate
+	hello    ServerHello
+	fastMap  LevelInitFastMap
+	chunk    LevelDataChunk
+	finalize LevelFinalize
+	spawn    SpawnPlayer
+	teleport SetPosition
+	despawn  DespawnPlayer
+	message  Message
+	kick     Kick
+	extInfo  ExtInfo
+	extEntry ExtEntry
+	envColor EnvSetColor
 }
 
 func NewServerEncoder(w io.Writer) *ServerEncoder {
-	return &ServerEncoder{w}
+	return &ServerEncoder{w: bufio.NewWriterSize(w, serverEncoderBufferSize)}
 }
 
-func (enc *ServerEncoder) writePacket(packet []byte) error {
-	n, err := enc.w.Write(packet)
-	if err != nil {
-		return err
+// WritePacket buffers a packet to be sent on the next Flush.
+func (enc *ServerEncoder) WritePacket(p Packet) error {
+	size := 1 + p.Size()
+	if cap(enc.buf) < size {
+		enc.buf = make([]byte, size)
 	}
 
-	if n != len(packet) {
-		return errors.New("write truncated packet")
+	packet := enc.buf[:size]
+	if err := enc.pb.encode(packet, p); err != nil {
+		return err
 	}
 
-	return nil
+	_, err := enc.w.Write(packet)
+	return err
+}
+
+// Flush sends all buffered packets.
+func (enc *ServerEncoder) Flush() error {
+	return enc.w.Flush()
 }
 
 func (enc *ServerEncoder) WriteServerHello(name, motd string, playerType PlayerType) error {
-	buf := make([]byte, 131)
-	buf[0] = PacketServerHello
-	buf[1] = ProtocolVersionClassic30
-	err := writeString(buf[2:66], name)
-	if err != nil {
-		return err
+	enc.hello = ServerHello{
+		ProtocolVersion: ProtocolVersionClassic30,
+		Name:            name,
+		MOTD:            motd,
+		UserType:        playerType,
 	}
-	err = writeString(buf[66:130], motd)
-	if err != nil {
-		return err
-	}
-	buf[130] = byte(playerType)
-
-	return enc.writePacket(buf)
+	return enc.WritePacket(&enc.hello)
 }
 
 func (enc *ServerEncoder) WriteLevelInit() error {
-	return enc.writePacket([]byte{PacketServerLevelInit})
+	return enc.WritePacket(&LevelInit{})
+}
+
+func (enc *ServerEncoder) WriteLevelInitFastMap(volume int) error {
+	enc.fastMap = LevelInitFastMap{int32(volume)}
+	return enc.WritePacket(&enc.fastMap)
 }
 
 func (enc *ServerEncoder) WriteLevelDataChunk(chunk []byte, sent, total int) error {
-	if len(chunk) > 1024 {
-		return errors.New("level data chunk too big")
-	}
 	if sent > total {
 		return errors.New("sent > total")
 	}
 
-	buf := make([]byte, 1028)
-	buf[0] = PacketServerLevelDataChunk
-	writeInt16(buf[1:3], int16(len(chunk)))
-
-	n := copy(buf[3:1027], chunk)
-	for n < 1024 {
-		buf[3+n] = 0x00
-		n++
-	}
+	enc.chunk.Data = chunk
+	enc.chunk.PercentComplete = byte(sent * 100 / total)
+	err := enc.WritePacket(&enc.chunk)
+	// Don't hold on to the level after it has been sent
+	enc.chunk.Data = nil
 
-	buf[1027] = byte(float64(sent) / float64(total) * 100)
-
-	return enc.writePacket(buf)
+	return err
 }
 
 func (enc *ServerEncoder) WriteLevelFinalize(width, depth, height int16) error {
-	buf := make([]byte, 7)
-	buf[0] = PacketServerLevelFinalize
-	writeInt16(buf[1:3], width)
-	writeInt16(buf[3:5], depth)
-	writeInt16(buf[5:7], height)
-
-	return enc.writePacket(buf)
+	enc.finalize = LevelFinalize{width, depth, height}
+	return enc.WritePacket(&enc.finalize)
 }
 
 func (enc *ServerEncoder) WriteSpawnPlayer(playerId int8, name string, x, y, z int16, yaw, pitch byte) error {
-	buf := make([]byte, 74)
-	buf[0] = PacketServerSpawnPlayer
-	buf[1] = byte(playerId)
-	err := writeString(buf[2:66], name)
-	if err != nil {
-		return err
-	}
-	writeInt16(buf[66:68], x)
-	writeInt16(buf[68:70], y)
-	writeInt16(buf[70:72], z)
-	buf[72] = yaw
-	buf[73] = pitch
-
-	return enc.writePacket(buf)
+	enc.spawn = SpawnPlayer{playerId, name, x, y, z, yaw, pitch}
+	return enc.WritePacket(&enc.spawn)
 }
 
-func (enc *ServerEncoder) WriteMessage(message string, sender int8) error {
-	buf := make([]byte, 66)
-	buf[0] = PacketServerMessage
-	buf[1] = byte(sender)
-	err := writeString(buf[2:], message)
-	if err != nil {
-		return err
-	}
-
-	return enc.writePacket(buf)
+func (enc *ServerEncoder) WriteTeleportPlayer(playerId int8, x, y, z int16, yaw, pitch byte) error {
+	enc.teleport = SetPosition{playerId, x, y, z, yaw, pitch}
+	return enc.WritePacket(&enc.teleport)
 }
 
-func (enc *ServerEncoder) WriteKick(reason string) error {
-	buf := make([]byte, 65)
-	buf[0] = PacketServerKick
-	err := writeString(buf[1:], reason)
-	if err != nil {
-		return err
-	}
-
-	return enc.writePacket(buf)
+func (enc *ServerEncoder) WriteDespawnPlayer(playerId int8) error {
+	enc.despawn = DespawnPlayer{playerId}
+	return enc.WritePacket(&enc.despawn)
 }
 
-type ClientDecoder struct {
-	r io.Reader
-}
-
-func NewClientDecoder(r io.Reader) *ClientDecoder {
-	return &ClientDecoder{r}
-}
-
-func (dec *ClientDecoder) readBuf(buf []byte) error {
-	n, err := dec.r.Read(buf)
-
-	if err != nil {
-		return err
-	} else if n != len(buf) {
-		return errors.New("truncated read")
-	}
-
-	return nil
+func (enc *ServerEncoder) WriteMessage(message string, sender int8) error {
+	enc.message = Message{sender, message}
+	return enc.WritePacket(&enc.message)
 }
 
-func (dec *ClientDecoder) readByte() (byte, error) {
-	buf := make([]byte, 1)
-
-	if err := dec.readBuf(buf); err != nil {
-		return 0, err
-	}
-
-	return buf[0], nil
+func (enc *ServerEncoder) WriteKick(reason string) error {
+	enc.kick = Kick{reason}
+	return enc.WritePacket(&enc.kick)
 }
 
-func (dec *ClientDecoder) readInt16() (i int16, err error) {
-	buf := make([]byte, 2)
-
-	if err := dec.readBuf(buf); err != nil {
-		return 0, err
-	}
-
-	return int16(buf[0])<<8 | int16(buf[1]), nil
+func (enc *ServerEncoder) WriteExtInfo(appName string, extensionCount int16) error {
+	enc.extInfo = ExtInfo{appName, extensionCount}
+	return enc.WritePacket(&enc.extInfo)
 }
 
-func (dec *ClientDecoder) readString() (string, error) {
-	buf := make([]byte, 64)
-
-	if err := dec.readBuf(buf); err != nil {
-		return "", err
-	}
-
-	return strings.TrimRight(string(buf), " "), nil
+func (enc *ServerEncoder) WriteExtEntry(name string, version int32) error {
+	enc.extEntry = ExtEntry{name, version}
+	return enc.WritePacket(&enc.extEntry)
 }
 
-func (dec *ClientDecoder) NextPacketID() (byte, error) {
-	id, err := dec.readByte()
-	if err != nil {
-		return 0, err
-	}
-	if id != PacketClientHello && id != PacketClientSetBlock && id != PacketClientPositionUpdate && id != PacketClientMessage {
-		return 0, errors.New("client sent invalid packet ID")
-	}
-
-	return id, nil
+func (enc *ServerEncoder) WriteEnvSetColor(variable byte, red, green, blue int16) error {
+	enc.envColor = EnvSetColor{variable, red, green, blue}
+	return enc.WritePacket(&enc.envColor)
 }
 
-func (dec *ClientDecoder) ReadClientHello() (name, mppass string, err error) {
-	protocolVersion, err := dec.readByte()
-	if err != nil {
-		return
-	} else if protocolVersion != ProtocolVersionClassic30 {
-		err = errors.New("invalid protocol version")
-		return
-	}
-
-	if name, err = dec.readString(); err != nil {
-		return
-	}
-	if mppass, err = dec.readString(); err != nil {
-		return
-	}
-	// Unused byte
-	if _, err = dec.readByte(); err != nil {
-		return
-	}
-
-	return name, mppass, err
-}
-
-func (dec *ClientDecoder) ReadSetBlock() (x, y, z int16, mode, blockType byte, err error) {
-	x, err = dec.readInt16()
-	if err != nil {
-		return
-	}
-	y, err = dec.readInt16()
-	if err != nil {
-		return
-	}
-	z, err = dec.readInt16()
-	if err != nil {
-		return
-	}
-	mode, err = dec.readByte()
-	if err != nil {
-		return
-	}
-	blockType, err = dec.readByte()
-
-	return
+// ClientDecoder reads one whole packet at a time, so packets split across
+// several TCP segments are reassembled before they are decoded.
+type ClientDecoder struct {
+	r *bufio.Reader
 }
 
-func (dec *ClientDecoder) ReadPositionUpdate() (x, y, z int16, yaw, pitch byte, err error) {
-	// PlayerID is always 255
-	if _, err = dec.readByte(); err != nil {
-		return
-	}
-	x, err = dec.readInt16()
-	if err != nil {
-		return
-	}
-	y, err = dec.readInt16()
-	if err != nil {
-		return
-	}
-	z, err = dec.readInt16()
-	if err != nil {
-		return
-	}
-	yaw, err = dec.readByte()
-	if err != nil {
-		return
-	}
-	pitch, err = dec.readByte()
-
-	return
+func NewClientDecoder(r io.Reader) *ClientDecoder {
+	return &ClientDecoder{bufio.NewReader(r)}
 }
 
-func (dec *ClientDecoder) ReadMessage() (message string, err error) {
-	// First byte is an unused ID
-	if _, err = dec.readByte(); err != nil {
-		return
-	}
-	message, err = dec.readString()
-
-	return
+// ReadPacket returns the next packet from the client.  io.EOF is only
+// returned if the connection was closed between packets.
+func (dec *ClientDecoder) ReadPacket() (Packet, error) {
+	return ReadPacket(dec.r, ClientPackets)
 }
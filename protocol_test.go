@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"testing/iotest"
+)
+
+var clientTestPackets = []Packet{
+	&ClientHello{ProtocolVersion: ProtocolVersionClassic30, Name: "alice", Mppass: "0123456789abcdef"},
+	&ClientHello{ProtocolVersion: ProtocolVersionClassic30, Name: "bob", Mppass: "-", Unused: ClientHelloCPEMagic},
+	&ClientSetBlock{X: 1, Y: -2, Z: 300, Mode: 1, BlockType: 45},
+	&SetPosition{PlayerID: -1, X: 1000, Y: 2000, Z: -3000, Yaw: 64, Pitch: 200},
+	&Message{PlayerID: -1, Message: "Hello, world!"},
+	&ExtInfo{AppName: "ClassiCube 1.3.6", ExtensionCount: 42},
+	&ExtEntry{ExtName: "FastMap", Version: 1},
+	&CustomBlockSupportLevel{SupportLevel: 1},
+	&PlayerClicked{Button: 1, Action: 0, Yaw: 16384, Pitch: -100, TargetEntityID: -1, TargetBlockX: 10, TargetBlockY: 20, TargetBlockZ: 300, TargetBlockFace: 4},
+	&TwoWayPing{Direction: 0, Data: -2},
+}
+
+// CPE packets sent by servers, which the server doesn't send itself yet
+var serverTestPackets = []Packet{
+	&EnvSetColor{Variable: EnvColorFog, Red: 10, Green: -1, Blue: 255},
+	&CustomBlockSupportLevel{SupportLevel: 1},
+	&TwoWayPing{Direction: 1, Data: 1234},
+	&SetClickDistance{Distance: 160},
+	&HoldThis{BlockToHold: 45, PreventChange: 1},
+	&SetTextHotKey{Label: "Levels", Action: "/levels\n", KeyCode: 38, KeyMods: 1},
+	&ExtAddPlayerName{NameID: 3, PlayerName: "alice", ListName: "&calice", GroupName: "Visitors", GroupRank: 2},
+	&ExtRemovePlayerName{NameID: 3},
+	&MakeSelection{SelectionID: 1, Label: "spawn", StartX: 1, StartY: 2, StartZ: 3, EndX: 10, EndY: 20, EndZ: 30, Red: 255, Green: 0, Blue: 128, Opacity: 100},
+	&RemoveSelection{SelectionID: 1},
+	&SetBlockPermission{BlockType: 7, AllowPlacement: 0, AllowDeletion: 0},
+	&ChangeModel{EntityID: -1, ModelName: "chicken"},
+	&EnvSetMapAppearance{TextureURL: "http://example.com/terrain.zip", SideBlock: 7, EdgeBlock: 8, SideLevel: 32, CloudsLevel: 66, MaxViewDistance: 0},
+	&EnvSetWeatherType{WeatherType: 2},
+	&HackControl{Flying: 1, NoClip: 0, Speeding: 1, SpawnControl: 1, ThirdPersonView: 0, JumpHeight: -1},
+	&ExtAddEntity2{EntityID: 4, InGameName: "bob", SkinName: "bob", X: 100, Y: -200, Z: 300, Yaw: 64, Pitch: 128},
+}
+
+// splitReader returns each of its parts from a separate Read, like data
+// arriving in several TCP segments.
+type splitReader struct {
+	parts [][]byte
+}
+
+func (r *splitReader) Read(buf []byte) (int, error) {
+	for len(r.parts) > 0 && len(r.parts[0]) == 0 {
+		r.parts = r.parts[1:]
+	}
+	if len(r.parts) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(buf, r.parts[0])
+	r.parts[0] = r.parts[0][n:]
+	return n, nil
+}
+
+func encodeTestPacket(t *testing.T, p Packet) []byte {
+	data, err := EncodePacket(p)
+	if err != nil {
+		t.Fatalf("encoding %T: %s", p, err)
+	}
+
+	return data
+}
+
+func TestReadPacketSplit(t *testing.T) {
+	for _, want := range clientTestPackets {
+		data := encodeTestPacket(t, want)
+
+		for k := 0; k <= len(data); k++ {
+			r := &splitReader{[][]byte{data[:k], data[k:]}}
+			got, err := NewClientDecoder(r).ReadPacket()
+			if err != nil {
+				t.Fatalf("%T split at %d: %s", want, k, err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("%T split at %d: got %+v, want %+v", want, k, got, want)
+			}
+		}
+	}
+}
+
+func TestReadPacketOneByteAtATime(t *testing.T) {
+	var stream []byte
+	for _, p := range clientTestPackets {
+		stream = append(stream, encodeTestPacket(t, p)...)
+	}
+
+	dec := NewClientDecoder(iotest.OneByteReader(bytes.NewReader(stream)))
+	for _, want := range clientTestPackets {
+		got, err := dec.ReadPacket()
+		if err != nil {
+			t.Fatalf("%T: %s", want, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %+v, want %+v", got, want)
+		}
+	}
+
+	if _, err := dec.ReadPacket(); err != io.EOF {
+		t.Fatalf("got %v at end of stream, want io.EOF", err)
+	}
+}
+
+func TestReadPacketTruncated(t *testing.T) {
+	for _, p := range clientTestPackets {
+		data := encodeTestPacket(t, p)
+
+		for k := 1; k < len(data); k++ {
+			_, err := NewClientDecoder(bytes.NewReader(data[:k])).ReadPacket()
+			if err != io.ErrUnexpectedEOF {
+				t.Fatalf("%T truncated to %d bytes: got %v, want io.ErrUnexpectedEOF", p, k, err)
+			}
+		}
+	}
+}
+
+func TestReadPacketEOFAtBoundary(t *testing.T) {
+	data := encodeTestPacket(t, clientTestPackets[0])
+	dec := NewClientDecoder(bytes.NewReader(data))
+
+	if _, err := dec.ReadPacket(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dec.ReadPacket(); err != io.EOF {
+		t.Fatalf("got %v, want io.EOF", err)
+	}
+}
+
+func TestReadPacketInvalidID(t *testing.T) {
+	_, err := NewClientDecoder(bytes.NewReader([]byte{0xff, 0, 0})).ReadPacket()
+	if err == nil {
+		t.Fatal("expected an error for an unknown packet ID")
+	}
+}
+
+func TestServerPacketsRoundTrip(t *testing.T) {
+	for _, want := range serverTestPackets {
+		data := encodeTestPacket(t, want)
+		if len(data) != 1+want.Size() {
+			t.Fatalf("%T encoded to %d bytes, want %d", want, len(data), 1+want.Size())
+		}
+		if ServerPackets[data[0]].size != want.Size() {
+			t.Fatalf("%T has ID 0x%02x, which isn't in ServerPackets", want, data[0])
+		}
+
+		got, err := DecodePacket(ServerPackets, data)
+		if err != nil {
+			t.Fatalf("decoding %T: %s", want, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %+v, want %+v", got, want)
+		}
+	}
+}
+
+func TestPacketTableSizes(t *testing.T) {
+	for _, table := range []PacketTable{ClientPackets, ServerPackets, ServerPacketsFastMap} {
+		for id, pt := range table {
+			p := pt.newPacket()
+			if p.Size() != pt.size {
+				t.Errorf("packet 0x%02x: table size %d, packet size %d", id, pt.size, p.Size())
+			}
+		}
+	}
+}
+
+func BenchmarkReadPacketSetPosition(b *testing.B) {
+	packet, err := EncodePacket(clientTestPackets[3])
+	if err != nil {
+		b.Fatal(err)
+	}
+	data := bytes.Repeat(packet, 1024)
+	r := bytes.NewReader(data)
+	dec := NewClientDecoder(r)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%1024 == 0 {
+			r.Reset(data)
+		}
+		if _, err := dec.ReadPacket(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkServerEncoderWriteLevelDataChunk(b *testing.B) {
+	payload, err := NewCachedLevel(benchmarkLevel()).Payload()
+	if err != nil {
+		b.Fatal(err)
+	}
+	enc := NewServerEncoder(ioutil.Discard)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(payload)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for sent := 0; sent < len(payload); sent += levelChunkSize {
+			end := sent + levelChunkSize
+			if end > len(payload) {
+				end = len(payload)
+			}
+			if err := enc.WriteLevelDataChunk(payload[sent:end], end, len(payload)); err != nil {
+				b.Fatal(err)
+			}
+		}
+		enc.Flush()
+	}
+}
+
+func BenchmarkServerEncoderWriteMessage(b *testing.B) {
+	enc := NewServerEncoder(ioutil.Discard)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := enc.WriteMessage("alice: hello everyone", 3); err != nil {
+			b.Fatal(err)
+		}
+	}
+	enc.Flush()
+}
+
+func BenchmarkServerEncoderWriteSpawnPlayer(b *testing.B) {
+	enc := NewServerEncoder(ioutil.Discard)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := enc.WriteSpawnPlayer(3, "alice", 1000, 2000, 3000, 64, 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+	enc.Flush()
+}
+
+// The less frequent packets, which shouldn't allocate either
+func BenchmarkServerEncoderWriteOther(b *testing.B) {
+	enc := NewServerEncoder(ioutil.Discard)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		enc.WriteServerHello("Museum", "Welcome", PlayerTypeNormal)
+		enc.WriteLevelInitFastMap(256 * 64 * 256)
+		enc.WriteLevelFinalize(256, 64, 256)
+		enc.WriteTeleportPlayer(3, 1000, 2000, 3000, 64, 0)
+		enc.WriteDespawnPlayer(3)
+		enc.WriteKick("Server is shutting down")
+		enc.WriteExtInfo("mcmuseum", 1)
+		if err := enc.WriteExtEntry(ExtFastMap, 1); err != nil {
+			b.Fatal(err)
+		}
+	}
+	enc.Flush()
+}
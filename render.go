@@ -0,0 +1,254 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+)
+
+// Approximate colors of the Classic blocks, used for previews.  Anything else
+// is drawn in magenta, which makes corrupt levels easy to spot.
+var blockColors = [...]color.RGBA{
+	0:  {0, 0, 0, 0},         // Air
+	1:  {125, 125, 125, 255}, // Stone
+	2:  {117, 176, 73, 255},  // Grass
+	3:  {134, 96, 67, 255},   // Dirt
+	4:  {115, 115, 115, 255}, // Cobblestone
+	5:  {157, 128, 79, 255},  // Planks
+	6:  {73, 204, 37, 255},   // Sapling
+	7:  {84, 84, 84, 255},    // Bedrock
+	8:  {38, 92, 255, 255},   // Water
+	9:  {38, 92, 255, 255},   // Still water
+	10: {244, 96, 0, 255},    // Lava
+	11: {244, 96, 0, 255},    // Still lava
+	12: {219, 211, 160, 255}, // Sand
+	13: {136, 126, 126, 255}, // Gravel
+	14: {143, 140, 125, 255}, // Gold ore
+	15: {136, 130, 127, 255}, // Iron ore
+	16: {110, 110, 110, 255}, // Coal ore
+	17: {102, 81, 51, 255},   // Log
+	18: {60, 192, 41, 255},   // Leaves
+	19: {183, 183, 57, 255},  // Sponge
+	20: {192, 245, 254, 255}, // Glass
+	21: {222, 50, 50, 255},   // Red wool
+	22: {222, 136, 50, 255},  // Orange wool
+	23: {222, 222, 50, 255},  // Yellow wool
+	24: {136, 222, 50, 255},  // Lime wool
+	25: {50, 222, 50, 255},   // Green wool
+	26: {50, 222, 136, 255},  // Aqua wool
+	27: {50, 222, 222, 255},  // Cyan wool
+	28: {104, 163, 222, 255}, // Blue wool
+	29: {120, 120, 222, 255}, // Purple wool
+	30: {136, 50, 222, 255},  // Indigo wool
+	31: {174, 74, 222, 255},  // Violet wool
+	32: {222, 50, 222, 255},  // Magenta wool
+	33: {222, 50, 136, 255},  // Pink wool
+	34: {77, 77, 77, 255},    // Black wool
+	35: {174, 174, 174, 255}, // Gray wool
+	36: {222, 222, 222, 255}, // White wool
+	37: {237, 255, 0, 255},   // Dandelion
+	38: {242, 0, 0, 255},     // Rose
+	39: {145, 109, 85, 255},  // Brown mushroom
+	40: {226, 18, 18, 255},   // Red mushroom
+	41: {232, 245, 46, 255},  // Gold block
+	42: {230, 230, 230, 255}, // Iron block
+	43: {168, 168, 168, 255}, // Double slab
+	44: {168, 168, 168, 255}, // Slab
+	45: {155, 87, 71, 255},   // Brick
+	46: {219, 68, 26, 255},   // TNT
+	47: {110, 90, 60, 255},   // Bookshelf
+	48: {101, 130, 101, 255}, // Mossy cobblestone
+	49: {20, 18, 29, 255},    // Obsidian
+}
+
+var unknownBlockColor = color.RGBA{255, 0, 255, 255}
+
+const (
+	RenderTopDown   = "topdown"
+	RenderIsometric = "iso"
+)
+
+func blockColor(block byte) color.RGBA {
+	if int(block) < len(blockColors) {
+		return blockColors[block]
+	}
+
+	return unknownBlockColor
+}
+
+func shade(c color.RGBA, factor float64) color.RGBA {
+	return color.RGBA{
+		byte(float64(c.R) * factor),
+		byte(float64(c.G) * factor),
+		byte(float64(c.B) * factor),
+		c.A,
+	}
+}
+
+// see-through blocks don't hide the blocks behind or below them
+func seeThrough(block byte) bool {
+	switch block {
+	case 0, 6, 20, 37, 38, 39, 40:
+		return true
+	}
+
+	return false
+}
+
+func (lvl *Level) block(x, y, z int) byte {
+	return lvl.Blocks[(y*int(lvl.Height)+z)*int(lvl.Width)+x]
+}
+
+// RenderLevel draws a preview of a level from the given view.
+func RenderLevel(lvl *Level, view string) (image.Image, error) {
+	if len(lvl.Blocks) != lvl.Volume() {
+		return nil, errors.New("RenderLevel: block array does not match level size")
+	}
+
+	switch view {
+	case RenderTopDown:
+		return RenderLevelTopDown(lvl), nil
+	case RenderIsometric:
+		return RenderLevelIsometric(lvl), nil
+	default:
+		return nil, fmt.Errorf("RenderLevel: unknown view %q", view)
+	}
+}
+
+// RenderLevelTopDown draws a map of the level as seen from above, one pixel
+// per column, darkening lower blocks.
+func RenderLevelTopDown(lvl *Level) *image.RGBA {
+	width, depth, height := int(lvl.Width), int(lvl.Depth), int(lvl.Height)
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for z := 0; z < height; z++ {
+		for x := 0; x < width; x++ {
+			for y := depth - 1; y >= 0; y-- {
+				block := lvl.block(x, y, z)
+				if seeThrough(block) {
+					continue
+				}
+
+				factor := 0.5 + 0.5*float64(y+1)/float64(depth)
+				img.SetRGBA(x, z, shade(blockColor(block), factor))
+				break
+			}
+		}
+	}
+
+	return img
+}
+
+// Size of a block in isometric renders, in pixels
+const (
+	isoBlockWidth  = 4
+	isoBlockHeight = 4
+)
+
+// RenderLevelIsometric draws the level from above its +X, +Z corner.  Each
+// block is a small cube with a light top and two darker sides.
+func RenderLevelIsometric(lvl *Level) *image.RGBA {
+	width, depth, height := int(lvl.Width), int(lvl.Depth), int(lvl.Height)
+	img := image.NewRGBA(image.Rect(0, 0, (width+height)*isoBlockWidth/2, (width+height)*isoBlockHeight/4+depth*isoBlockHeight/2))
+
+	originX := (height - 1) * isoBlockWidth / 2
+	// The top of the highest block in the back corner is the top row
+	originY := (depth - 1) * isoBlockHeight / 2
+
+	hidden := func(x, y, z int) bool {
+		return x+1 < width && y+1 < depth && z+1 < height &&
+			!seeThrough(lvl.block(x+1, y, z)) &&
+			!seeThrough(lvl.block(x, y+1, z)) &&
+			!seeThrough(lvl.block(x, y, z+1))
+	}
+
+	// Draw back to front; the view direction is (1, 1, 1), so nothing can be
+	// hidden by a block with a smaller x + y + z
+	for sum := 0; sum < width+depth+height-2; sum++ {
+		for y := 0; y < depth && y <= sum; y++ {
+			for x := 0; x < width && x <= sum-y; x++ {
+				z := sum - y - x
+				if z >= height {
+					continue
+				}
+
+				block := lvl.block(x, y, z)
+				if block == 0 || hidden(x, y, z) {
+					continue
+				}
+
+				c := blockColor(block)
+				top, left, right := c, shade(c, 0.8), shade(c, 0.6)
+
+				sx := originX + (x-z)*isoBlockWidth/2
+				sy := originY + (x+z)*isoBlockHeight/4 - y*isoBlockHeight/2
+				img.SetRGBA(sx+1, sy, top)
+				img.SetRGBA(sx+2, sy, top)
+				for i := 0; i < 4; i++ {
+					img.SetRGBA(sx+i, sy+1, top)
+				}
+				for j := 2; j < 4; j++ {
+					img.SetRGBA(sx, sy+j, left)
+					img.SetRGBA(sx+1, sy+j, left)
+					img.SetRGBA(sx+2, sy+j, right)
+					img.SetRGBA(sx+3, sy+j, right)
+				}
+			}
+		}
+	}
+
+	return img
+}
+
+func runRender(args []string) error {
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	view := flags.String("view", RenderTopDown, "View to render: topdown or iso")
+	format := flags.String("format", "", "Level format (detected if not set)")
+	output := flags.String("o", "", "Output PNG file (default: level filename with .png)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s render [flags] level\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("expected one level file")
+	}
+	filename := flags.Arg(0)
+
+	var lvl *Level
+	var err error
+	if *format != "" {
+		lvl, err = ReadLevelFormat(filename, *format)
+	} else {
+		lvl, err = ReadLevel(filename)
+	}
+	if err != nil {
+		return err
+	}
+
+	img, err := RenderLevel(lvl, *view)
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		*output = filename + ".png"
+	}
+	file, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := png.Encode(file, img); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+func TestRenderLevelIsometricBounds(t *testing.T) {
+	lvl := &Level{Width: 1, Depth: 1, Height: 1, Blocks: []byte{1}}
+	img := RenderLevelIsometric(lvl)
+
+	if want := image.Rect(0, 0, isoBlockWidth, isoBlockHeight); img.Bounds() != want {
+		t.Fatalf("got bounds %v, want %v", img.Bounds(), want)
+	}
+
+	stone := blockColor(1)
+	for _, test := range []struct {
+		x, y   int
+		opaque bool
+	}{
+		{0, 0, false}, {1, 0, true}, {2, 0, true}, {3, 0, false},
+		{0, 1, true}, {3, 1, true},
+		// The sides reach the bottom row
+		{0, 3, true}, {3, 3, true},
+	} {
+		c := img.RGBAAt(test.x, test.y)
+		if opaque := c.A != 0; opaque != test.opaque {
+			t.Errorf("pixel %d,%d: got %v, want opaque = %v", test.x, test.y, c, test.opaque)
+		}
+	}
+	if c := img.RGBAAt(1, 0); c != stone {
+		t.Errorf("got top %v, want %v", c, stone)
+	}
+}
+
+func TestRenderLevelIsometricFitsLevel(t *testing.T) {
+	lvl := &Level{Width: 3, Depth: 2, Height: 5}
+	lvl.Blocks = make([]byte, lvl.Volume())
+	for i := range lvl.Blocks {
+		lvl.Blocks[i] = 1
+	}
+	img := RenderLevelIsometric(lvl)
+	bounds := img.Bounds()
+
+	// The highest back corner block touches the top row, and the lowest
+	// front corner block the bottom row
+	for _, y := range []int{bounds.Min.Y, bounds.Max.Y - 1} {
+		if rowEmpty(img, y) {
+			t.Errorf("row %d of %v is empty", y, bounds)
+		}
+	}
+}
+
+func rowEmpty(img *image.RGBA, y int) bool {
+	for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+		if img.RGBAAt(x, y).A != 0 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+// Entity IDs 0-126 are available for other players; -1 always refers to the
+// client itself.
+const maxRoomPlayers = 127
+
+var ErrRoomFull = errors.New("room is full")
+
+type roomPlayer struct {
+	client     *Client
+	x, y, z    int16
+	yaw, pitch byte
+}
+
+// Room tracks the players currently visiting a level, assigns them entity IDs
+// and relays their movement to each other.
+type Room struct {
+	Level string
+
+	mutex   sync.Mutex
+	players [maxRoomPlayers]*roomPlayer
+}
+
+func (m *Museum) Room(level string) *Room {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	room, ok := m.rooms[level]
+	if !ok {
+		room = &Room{Level: level}
+		m.rooms[level] = room
+	}
+
+	return room
+}
+
+// Join adds a client at the given spawn point, spawning it for everyone else
+// in the room and spawning everyone else for it.
+func (r *Room) Join(c *Client, spawn Spawnpoint) (int8, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	id := -1
+	for i, p := range r.players {
+		if p == nil {
+			id = i
+			break
+		}
+	}
+	if id < 0 {
+		return -1, ErrRoomFull
+	}
+
+	joined := &roomPlayer{
+		client: c,
+		x:      spawn.X,
+		y:      spawn.Y,
+		z:      spawn.Z,
+		yaw:    spawn.RotX,
+		pitch:  spawn.RotY,
+	}
+
+	for i, p := range r.players {
+		if p == nil {
+			continue
+		}
+
+		c.sendSpawnPlayer(int8(i), p.client.name, p.x, p.y, p.z, p.yaw, p.pitch)
+		p.client.sendSpawnPlayer(int8(id), c.name, joined.x, joined.y, joined.z, joined.yaw, joined.pitch)
+	}
+
+	r.players[id] = joined
+	return int8(id), nil
+}
+
+// Leave removes a player, despawning it for everyone else in the room and
+// everyone else for it.  Clients don't necessarily clear entities when a new
+// level is sent, so the leaving player needs the despawns too.
+func (r *Room) Leave(id int8) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	left := r.players[id]
+	r.players[id] = nil
+	for i, p := range r.players {
+		if p == nil {
+			continue
+		}
+
+		p.client.sendDespawnPlayer(id)
+		if left != nil {
+			left.client.sendDespawnPlayer(int8(i))
+		}
+	}
+}
+
+// Move records a player's new position and relays it to everyone else.
+func (r *Room) Move(id int8, x, y, z int16, yaw, pitch byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	moved := r.players[id]
+	if moved == nil {
+		return
+	}
+
+	// Clients send their position constantly, even when standing still
+	if moved.x == x && moved.y == y && moved.z == z && moved.yaw == yaw && moved.pitch == pitch {
+		return
+	}
+	moved.x, moved.y, moved.z, moved.yaw, moved.pitch = x, y, z, yaw, pitch
+
+	for i, p := range r.players {
+		if p != nil && int8(i) != id {
+			p.client.sendTeleportPlayer(id, x, y, z, yaw, pitch)
+		}
+	}
+}
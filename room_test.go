@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestRoomLeaveDespawnsBothWays(t *testing.T) {
+	aliceBuf, bobBuf := new(bytes.Buffer), new(bytes.Buffer)
+	alice, bob := newTestClient(aliceBuf), newTestClient(bobBuf)
+	alice.name, bob.name = "alice", "bob"
+
+	room := &Room{Level: "test"}
+	aliceID, err := room.Join(alice, Spawnpoint{X: 1, Y: 2, Z: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobID, err := room.Join(bob, Spawnpoint{X: 4, Y: 5, Z: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.Leave(bobID)
+
+	// Nothing was sent in between, so the spawns are coalesced away
+	want := []Packet{&DespawnPlayer{aliceID}}
+	if got := drainOutbox(t, bob, bobBuf); !reflect.DeepEqual(got, want) {
+		t.Fatalf("bob got %+v, want %+v", got, want)
+	}
+
+	want = []Packet{&DespawnPlayer{bobID}}
+	if got := drainOutbox(t, alice, aliceBuf); !reflect.DeepEqual(got, want) {
+		t.Fatalf("alice got %+v, want %+v", got, want)
+	}
+}
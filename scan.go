@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ScanSource finds levels by recursively scanning directories, instead of
+// reading a hand-written manifest.  Levels are named after their filename;
+// when several files share a name, the first one (in path order) keeps it and
+// the rest get a numeric suffix.
+type ScanSource struct {
+	Dirs []string
+	// If set, the generated level list is written here after every scan
+	ManifestFilename string
+}
+
+func (src *ScanSource) walk(visit func(path string, info os.FileInfo)) error {
+	// Don't pick up our own output
+	var manifest string
+	if src.ManifestFilename != "" {
+		manifest, _ = filepath.Abs(src.ManifestFilename)
+	}
+
+	for _, dir := range src.Dirs {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() && path != dir && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			if abs, _ := filepath.Abs(path); abs == manifest {
+				return nil
+			}
+
+			visit(path, info)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (src *ScanSource) ReadLevels() ([]LevelDescriptor, error) {
+	files := []string{}
+	mtimes := make(map[string]os.FileInfo)
+	err := src.walk(func(path string, info os.FileInfo) {
+		files = append(files, path)
+		mtimes[path] = info
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	levels := []LevelDescriptor{}
+	names := make(map[string]bool)
+	skipped := 0
+	for _, path := range files {
+		level, err := scanLevel(path, mtimes[path])
+		if err != nil {
+			log.Printf("Skipping %s: %s", path, err.Error())
+			skipped++
+			continue
+		}
+
+		base := level.Name
+		for i := 2; names[level.Name]; i++ {
+			level.Name = fmt.Sprintf("%s-%d", base, i)
+		}
+		names[level.Name] = true
+
+		levels = append(levels, level)
+	}
+
+	log.Printf("Found %d levels in %s (%d files skipped)", len(levels), src, skipped)
+
+	if src.ManifestFilename != "" {
+		if err := WriteManifest(src.ManifestFilename, levels); err != nil {
+			log.Printf("[ERROR] Failed to write %s: %s", src.ManifestFilename, err.Error())
+		} else {
+			log.Printf("Wrote generated manifest to %s", src.ManifestFilename)
+		}
+	}
+
+	return levels, nil
+}
+
+func scanLevel(path string, info os.FileInfo) (LevelDescriptor, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return LevelDescriptor{}, err
+	}
+	format, err := DetectLevelFormat(path, file)
+	file.Close()
+	if err != nil {
+		return LevelDescriptor{}, err
+	}
+
+	lvl, err := ReadLevelFormat(path, format.Name)
+	if err != nil {
+		return LevelDescriptor{}, err
+	}
+
+	base := filepath.Base(path)
+	name := strings.TrimSuffix(base, filepath.Ext(base))
+	// Names are used as /goto arguments
+	name = strings.Join(strings.Fields(name), "_")
+
+	level := LevelDescriptor{
+		Name:             name,
+		Path:             path,
+		Format:           format.Name,
+		OriginalFilename: base,
+	}
+	level.applyMetadata(lvl)
+
+	created := level.Created
+	if created.IsZero() {
+		created = info.ModTime()
+	}
+	level.Datestring = created.UTC().Format("January 2, 2006")
+
+	return level, nil
+}
+
+// Version hashes the path, size and modification time of every file, so
+// adding, removing or replacing a level triggers a rescan.
+func (src *ScanSource) Version() (string, error) {
+	h := sha1.New()
+	err := src.walk(func(path string, info os.FileInfo) {
+		fmt.Fprintf(h, "%s\x00%d\x00%d\n", path, info.Size(), info.ModTime().UnixNano())
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (src *ScanSource) String() string {
+	return strings.Join(src.Dirs, ", ")
+}
@@ -1,27 +1,65 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"math/rand"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
 var (
-	ServerName      = flag.String("name", "", "Server name")
-	ServerMOTD      = flag.String("motd", "", "Server MOTD")
-	ManifestFile    = flag.String("manifest", "manifest.csv", "Level manifest file")
-	Port            = flag.Int("port", 25565, "Port to listen on")
-	ConnectionLimit = flag.Int("maxconns", 32, "Maximum number of connected players")
-	SendHeartbeat   = flag.Bool("heartbeat", false, "Send heartbeats to classicube.net")
-	Public          = flag.Bool("public", false, "List the server publicly on classicube.net")
+	ServerName        = flag.String("name", "", "Server name")
+	ServerMOTD        = flag.String("motd", "", "Server MOTD")
+	ManifestFile      = flag.String("manifest", "manifest.csv", "Level manifest file")
+	ScanDirs          = flag.String("scan", "", "Comma-separated directories to scan for levels, instead of reading -manifest")
+	GeneratedManifest = flag.String("writemanifest", "", "With -scan, write the generated level list to this file (.csv or .json)")
+	Port              = flag.Int("port", 25565, "Port to listen on")
+	HTTPAddr          = flag.String("http", "", "Serve a web gallery of the museum on this address, e.g. :8080")
+	ConnectionLimit   = flag.Int("maxconns", 32, "Maximum number of connected players")
+	PerIPLimit        = flag.Int("maxperip", 4, "Maximum number of connections from a single IP address (0 for no limit)")
+	ReservedSlots     = flag.Int("reservedslots", 0, "Number of -maxconns slots only operators may use")
+	Operators         = flag.String("operators", "", "Comma-separated operator names, who may use reserved slots")
+	VerifyNames       = flag.String("verifynames", VerifyNamesOff, "Verify player names against the classicube.net mppass: off, warn or enforce")
+	VerifyBypass      = flag.String("verifybypass", VerifyBypassNone, "Skip name verification for trusted addresses: none, localhost or lan")
+	SendHeartbeat     = flag.Bool("heartbeat", false, "Send heartbeats to classicube.net")
+	Public            = flag.Bool("public", false, "List the server publicly on classicube.net")
+	CacheSize         = flag.Int("cachesize", 64, "Memory budget for cached levels, in MiB (0 to disable)")
+	ShutdownMessage   = flag.String("shutdownmessage", "Server is shutting down", "Kick message sent to players when the server stops")
+	ShutdownTimeout   = flag.Duration("shutdowntimeout", 5*time.Second, "How long to wait for players to receive the shutdown message")
+	IdleTimeout       = flag.Duration("idletimeout", 30*time.Minute, "Kick players who haven't moved or chatted for this long (0 to disable)")
+	ValidateMode      = flag.String("validate", ValidateOff, "Check every level when loading the level list: off, skip (leave broken levels out) or strict (refuse to start)")
+	ReloadInterval    = flag.Duration("reloadinterval", 10*time.Second, "How often to check the manifest or scanned directories for changes (0 to only reload on SIGHUP)")
 )
 
+// Tools that can be run instead of the server, e.g. "mcmuseum render ..."
+var subcommands = map[string]func(args []string) error{
+	"render":   runRender,
+	"convert":  runConvert,
+	"validate": runValidate,
+}
+
 func main() {
 	log.SetFlags(log.Ldate | log.Ltime | log.LUTC | log.Lshortfile)
 
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				log.Fatalf("%s: %s", os.Args[1], err.Error())
+			}
+			return
+		}
+	}
+
 	flag.Parse()
 
 	if *ServerName == "" {
@@ -31,25 +69,76 @@ func main() {
 	if *Public && !*SendHeartbeat {
 		log.Fatalf("-public is only permitted if -heartbeat is set")
 	}
+	if err := checkVerifyMode(*VerifyNames, *VerifyBypass); err != nil {
+		log.Fatalf("%s", err.Error())
+	}
+	if *VerifyNames != VerifyNamesOff && !*SendHeartbeat {
+		log.Fatalf("-verifynames is only permitted if -heartbeat is set")
+	}
+	if *ReservedSlots < 0 || *ReservedSlots > *ConnectionLimit {
+		log.Fatalf("-reservedslots must be between 0 and -maxconns")
+	}
+	if err := checkValidateMode(*ValidateMode); err != nil {
+		log.Fatalf("%s", err.Error())
+	}
+	if len(*ShutdownMessage) > 64 {
+		log.Fatalf("-shutdownmessage must be at most 64 bytes")
+	}
 
 	rand.Seed(time.Now().Unix())
+	var source LevelSource = &ManifestSource{*ManifestFile}
+	if *ScanDirs != "" {
+		source = &ScanSource{
+			Dirs:             strings.Split(*ScanDirs, ","),
+			ManifestFilename: *GeneratedManifest,
+		}
+	} else if *GeneratedManifest != "" {
+		log.Fatalf("-writemanifest is only permitted if -scan is set")
+	}
+	if *ValidateMode != ValidateOff {
+		source = &ValidatingSource{source, *ValidateMode == ValidateStrict}
+	}
+
 	museum, err := NewMuseum(
 		*ServerName,
 		*ServerMOTD,
-		*ManifestFile)
+		source,
+		*CacheSize<<20)
 	if err != nil {
-		log.Fatalf("Failed to load %s: %s", *ManifestFile, err.Error())
+		log.Fatalf("Failed to load %s: %s", source, err.Error())
 	}
 
-	connects := make(chan bool)
-	disconnects := make(chan bool)
-
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", *Port))
+	server := NewServer(museum)
+	server.MaxClients = *ConnectionLimit
+	server.MaxPerIP = *PerIPLimit
+	server.ReservedSlots = *ReservedSlots
+	server.VerifyNames = *VerifyNames
+	server.VerifyBypass = *VerifyBypass
+	server.IdleTimeout = *IdleTimeout
+	server.Salt, err = NewSalt()
 	if err != nil {
+		log.Fatalf("Failed to generate salt: %s", err.Error())
+	}
+	if *Operators != "" {
+		for _, name := range strings.Split(*Operators, ",") {
+			server.Operators[strings.ToLower(strings.TrimSpace(name))] = true
+		}
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go museum.WatchLevels(*ReloadInterval, hup, server.announceNewLevels)
+
+	if err := server.Listen(fmt.Sprintf(":%d", *Port)); err != nil {
 		log.Fatalf("Failed to start listener: %s", err.Error())
 	}
 	log.Printf("Listening on :%d", *Port)
 
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM)
+
+	stopHeartbeats := make(chan struct{})
+	heartbeatsDone := make(chan struct{})
 	if *SendHeartbeat {
 		log.Printf("Sending initial heartbeat")
 
@@ -59,7 +148,7 @@ func main() {
 			NumConnected:    0,
 			ConnectionLimit: *ConnectionLimit,
 			Public:          *Public,
-			Salt:            "PJSalt", // Salt not really needed since this server doesn't verify names
+			Salt:            server.Salt,
 		}
 
 		playURL, err := hb.Send()
@@ -68,36 +157,300 @@ func main() {
 		}
 
 		log.Printf("Play URL = %s", playURL)
-		go startHeartbeats(hb, connects, disconnects)
+		server.PlayURL = playURL
+		go func() {
+			startHeartbeats(hb, server, stopHeartbeats)
+			close(heartbeatsDone)
+		}()
 	} else {
+		close(heartbeatsDone)
+	}
+
+	if *HTTPAddr != "" {
+		gallery := NewGallery(server)
+		gallery.PlayURL = server.PlayURL
+		gallery.GamePort = *Port
+
+		log.Printf("Serving gallery on %s", *HTTPAddr)
 		go func() {
-			for {
-				select {
-				case <-connects:
-				case <-disconnects:
-				}
-			}
+			log.Fatalf("Gallery failed: %s", http.ListenAndServe(*HTTPAddr, gallery).Error())
 		}()
 	}
 
+	go func() {
+		if err := server.Serve(); err != nil {
+			log.Fatalf("Failed to accept connection: %s", err.Error())
+		}
+	}()
+
+	sig := <-shutdown
+	log.Printf("Received %s, shutting down", sig)
+
+	server.Shutdown(*ShutdownMessage, *ShutdownTimeout)
+	close(stopHeartbeats)
+	select {
+	case <-heartbeatsDone:
+	case <-time.After(*ShutdownTimeout):
+		log.Printf("[ERROR] Timed out sending final heartbeat")
+	}
+}
+
+var ErrServerFull = errors.New("server is full")
+
+// Server owns the listener and keeps track of every logged in client.
+type Server struct {
+	// Players beyond MaxClients are kicked after the handshake; the last
+	// ReservedSlots of them are only available to Operators (lowercase names).
+	MaxClients    int
+	ReservedSlots int
+	Operators     map[string]bool
+	// Connections beyond MaxPerIP from one address are kicked before they
+	// get a chance to log in.  0 means no limit.
+	MaxPerIP int
+
+	// Players who neither move nor chat for this long are kicked.  0 means
+	// no limit.
+	IdleTimeout time.Duration
+
+	// Returned by the first heartbeat
+	PlayURL string
+
+	// Sent in heartbeats; see auth.go
+	Salt         string
+	VerifyNames  string
+	VerifyBypass string
+
+	museum   *Museum
+	listener net.Listener
+
+	mutex   sync.Mutex
+	closing bool
+	clients map[*Client]bool
+	// Open connections by IP address, including those still handshaking
+	addrs map[string]int
+}
+
+func NewServer(museum *Museum) *Server {
+	return &Server{
+		Operators: make(map[string]bool),
+		museum:    museum,
+		clients:   make(map[*Client]bool),
+		addrs:     make(map[string]int),
+	}
+}
+
+func (s *Server) Listen(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	s.listener = listener
+	return nil
+}
+
+// Serve accepts connections until the listener fails, or until Shutdown is
+// called (in which case it returns nil).
+func (s *Server) Serve() error {
 	for {
-		conn, err := listener.Accept()
+		conn, err := s.listener.Accept()
 		if err != nil {
-			log.Fatalf("Failed to accept connection: %s", err.Error())
+			if s.isClosing() {
+				return nil
+			}
+			return err
 		}
 
-		log.Printf("Accepted connection from %s", conn.RemoteAddr())
-		connects <- true
-		client := &Client{
-			conn:    conn,
-			encoder: NewServerEncoder(conn),
-			decoder: NewClientDecoder(conn),
-			museum:  museum,
+		ip := addrIP(conn.RemoteAddr())
+		if !s.acquireAddr(ip) {
+			log.Printf("Refusing connection from %s: too many connections from %s", conn.RemoteAddr(), ip)
+			go refuseConnection(conn, "Too many connections from your address")
+			continue
 		}
 
-		go func() {
-			client.MainLoop()
-			disconnects <- true
-		}()
+		log.Printf("Accepted connection from %s", conn.RemoteAddr())
+		go func(conn net.Conn) {
+			defer s.releaseAddr(ip)
+
+			transport, err := acceptTransport(conn)
+			if err != nil {
+				log.Printf("[ERROR] Dropping connection from %s: %s", conn.RemoteAddr(), err.Error())
+				conn.Close()
+				return
+			}
+
+			NewClient(transport, s).MainLoop()
+		}(conn)
 	}
 }
+
+// refuseConnection kicks a connection that isn't accepted.  The kick is only
+// sent once it's known whether the client is using WebSocket, and it doesn't
+// count towards the client's address, so it must finish quickly.
+func refuseConnection(conn net.Conn, reason string) {
+	defer conn.Close()
+
+	conn.SetWriteDeadline(time.Now().Add(handshakeTimeout))
+	transport, err := acceptTransport(conn)
+	if err != nil {
+		return
+	}
+
+	encoder := NewServerEncoder(transport)
+	if encoder.WriteKick(reason) == nil {
+		encoder.Flush()
+	}
+}
+
+func (s *Server) isClosing() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.closing
+}
+
+// Shutdown stops accepting connections and kicks every player with the
+// given reason, waiting up to timeout for the kicks to be delivered.
+func (s *Server) Shutdown(reason string, timeout time.Duration) {
+	s.mutex.Lock()
+	s.closing = true
+	s.mutex.Unlock()
+
+	s.listener.Close()
+
+	clients := s.Clients()
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		wg.Add(1)
+		go func(c *Client) {
+			defer wg.Done()
+			c.Kick(reason)
+			c.close()
+		}(c)
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Printf("Disconnected %d players", len(clients))
+	case <-time.After(timeout):
+		log.Printf("[ERROR] Timed out disconnecting %d players", len(clients))
+	}
+}
+
+func addrIP(addr net.Addr) string {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.IP.String()
+	}
+
+	return addr.String()
+}
+
+func (s *Server) acquireAddr(ip string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.MaxPerIP > 0 && s.addrs[ip] >= s.MaxPerIP {
+		return false
+	}
+
+	s.addrs[ip]++
+	return true
+}
+
+func (s *Server) releaseAddr(ip string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.addrs[ip]--
+	if s.addrs[ip] <= 0 {
+		delete(s.addrs, ip)
+	}
+}
+
+func (s *Server) IsOperator(name string) bool {
+	return s.Operators[strings.ToLower(name)]
+}
+
+// register adds a client that has finished its handshake, unless the server
+// is full.  Reserved slots are only given to operators whose names have been
+// verified, or to anyone using an operator's name if verification is off.
+func (s *Server) register(c *Client) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	limit := s.MaxClients
+	if !s.IsOperator(c.name) || !(c.verified || s.VerifyNames == VerifyNamesOff) {
+		limit -= s.ReservedSlots
+	}
+	if len(s.clients) >= limit {
+		return ErrServerFull
+	}
+
+	s.clients[c] = true
+	return nil
+}
+
+func (s *Server) unregister(c *Client) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.clients, c)
+}
+
+// Clients returns every logged in client, in order of connection.
+func (s *Server) Clients() []*Client {
+	s.mutex.Lock()
+	clients := make([]*Client, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mutex.Unlock()
+
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].connectTime.Before(clients[j].connectTime)
+	})
+
+	return clients
+}
+
+func (s *Server) Each(fn func(c *Client)) {
+	for _, c := range s.Clients() {
+		fn(c)
+	}
+}
+
+// Lookup finds a logged in client by name, ignoring case.
+func (s *Server) Lookup(name string) *Client {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for c := range s.clients {
+		if strings.EqualFold(c.name, name) {
+			return c
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) NumClients() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.clients)
+}
+
+func (s *Server) Broadcast(message string, sender int8) {
+	s.Each(func(c *Client) {
+		c.SendMessage(message, sender)
+	})
+}
+
+func (s *Server) announceNewLevels(added []string) {
+	s.Broadcast("New levels added: &c"+strings.Join(added, "&e, &c"), MessageSenderServer)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRegisterReservedSlots(t *testing.T) {
+	for _, test := range []struct {
+		verifyNames string
+		name        string
+		verified    bool
+		admitted    bool
+	}{
+		{VerifyNamesOff, "Alice", false, true},
+		{VerifyNamesOff, "mallory", false, false},
+		{VerifyNamesWarn, "alice", true, true},
+		{VerifyNamesWarn, "alice", false, false},
+		{VerifyNamesEnforce, "alice", true, true},
+		{VerifyNamesEnforce, "alice", false, false},
+	} {
+		s := NewServer(nil)
+		s.MaxClients = 2
+		s.ReservedSlots = 1
+		s.VerifyNames = test.verifyNames
+		s.Operators["alice"] = true
+
+		if err := s.register(&Client{name: "bob"}); err != nil {
+			t.Fatal(err)
+		}
+
+		err := s.register(&Client{name: test.name, verified: test.verified})
+		if admitted := err == nil; admitted != test.admitted {
+			t.Errorf("%s (verified %v) with -verifynames %s: admitted %v, want %v",
+				test.name, test.verified, test.verifyNames, admitted, test.admitted)
+		}
+	}
+}
+
+func TestPerIPLimitKicksWebSocketClients(t *testing.T) {
+	s := NewServer(nil)
+	s.MaxPerIP = 1
+	if err := s.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve()
+	defer s.listener.Close()
+	addr := s.listener.Addr().String()
+
+	// Holds the only slot for this address while it handshakes
+	first, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.acquireAddr("127.0.0.1") {
+		s.releaseAddr("127.0.0.1")
+		if time.Now().After(deadline) {
+			t.Fatal("first connection was never accepted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n", addr)
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("got status %d, want 101", resp.StatusCode)
+	}
+
+	// An unmasked binary frame holding the kick
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		t.Fatal(err)
+	}
+	if header[0] != 0x80|wsOpBinary {
+		t.Fatalf("got frame header 0x%x, want a binary frame", header[0])
+	}
+	payload := make([]byte, header[1])
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatal(err)
+	}
+	if len(payload) == 0 || payload[0] != PacketServerKick {
+		t.Fatalf("got packet % x, want a kick", payload)
+	}
+}
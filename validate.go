@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+// Highest block ID (obsidian) a Classic 0.30 client can display.  Anything
+// above it crashes or confuses clients, since no block extensions are
+// negotiated.
+const maxClassicBlock = 49
+
+const (
+	ValidateOff    = "off"
+	ValidateSkip   = "skip"
+	ValidateStrict = "strict"
+)
+
+func checkValidateMode(mode string) error {
+	switch mode {
+	case ValidateOff, ValidateSkip, ValidateStrict:
+		return nil
+	default:
+		return fmt.Errorf("-validate must be %s, %s or %s", ValidateOff, ValidateSkip, ValidateStrict)
+	}
+}
+
+// LevelReport lists what is wrong with a level, if anything.
+type LevelReport struct {
+	Level    LevelDescriptor
+	Problems []string
+}
+
+func (r LevelReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// ValidateLevel checks a loaded level for problems that would stop it from
+// being sent to, or played by, a client.
+func ValidateLevel(lvl *Level) []string {
+	problems := []string{}
+
+	if err := lvl.checkDimensions(); err != nil {
+		return append(problems, fmt.Sprintf("invalid dimensions %d x %d x %d", lvl.Width, lvl.Depth, lvl.Height))
+	}
+	if len(lvl.Blocks) != lvl.Volume() {
+		return append(problems, fmt.Sprintf("%d blocks for a %d x %d x %d level", len(lvl.Blocks), lvl.Width, lvl.Depth, lvl.Height))
+	}
+
+	unknown, first := 0, -1
+	for i, block := range lvl.Blocks {
+		if block > maxClassicBlock {
+			if unknown == 0 {
+				first = i
+			}
+			unknown++
+		}
+	}
+	if unknown > 0 {
+		x := first % int(lvl.Width)
+		z := first / int(lvl.Width) % int(lvl.Height)
+		y := first / int(lvl.Width) / int(lvl.Height)
+		problems = append(problems, fmt.Sprintf(
+			"%d unknown block IDs (first is %d at %d, %d, %d)",
+			unknown, lvl.Blocks[first], x, y, z))
+	}
+
+	if problem := checkSpawn(lvl, lvl.Spawn); problem != "" {
+		problems = append(problems, problem)
+	}
+
+	return problems
+}
+
+// checkSpawn makes sure a spawn point is above the bottom of the level and
+// within its walls.  Spawning above the top is fine; the player just falls.
+func checkSpawn(lvl *Level, spawn Spawnpoint) string {
+	x, y, z := int(spawn.X)>>5, int(spawn.Y)>>5, int(spawn.Z)>>5
+	if spawn.X < 0 || x >= int(lvl.Width) || spawn.Z < 0 || z >= int(lvl.Height) || spawn.Y < 0 {
+		return fmt.Sprintf("spawn point %d, %d, %d is outside the level", x, y, z)
+	}
+
+	return ""
+}
+
+// verifyLevelFile reads a gzipped level file to the end, so that truncated
+// or corrupted files are caught even if the block array itself was intact.
+func verifyLevelFile(path string, format *LevelFormat) error {
+	if !format.Gzipped {
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gzin, err := gzip.NewReader(bufio.NewReader(file))
+	if err != nil {
+		return fmt.Errorf("corrupt gzip stream: %s", err.Error())
+	}
+	defer gzin.Close()
+
+	if _, err := io.Copy(ioutil.Discard, gzin); err != nil {
+		return fmt.Errorf("corrupt gzip stream: %s", err.Error())
+	}
+
+	return nil
+}
+
+func validateLevel(level LevelDescriptor) LevelReport {
+	report := LevelReport{Level: level}
+
+	file, err := os.Open(level.Path)
+	if err != nil {
+		report.Problems = append(report.Problems, err.Error())
+		return report
+	}
+
+	var format *LevelFormat
+	if level.Format != "" {
+		format = GetLevelFormat(level.Format)
+		if format == nil {
+			err = fmt.Errorf("unknown level format %s", level.Format)
+		}
+	} else {
+		format, err = DetectLevelFormat(level.Path, file)
+	}
+	file.Close()
+	if err != nil {
+		report.Problems = append(report.Problems, err.Error())
+		return report
+	}
+
+	if err := verifyLevelFile(level.Path, format); err != nil {
+		report.Problems = append(report.Problems, err.Error())
+		return report
+	}
+
+	lvl, err := ReadLevelFormat(level.Path, format.Name)
+	if err != nil {
+		report.Problems = append(report.Problems, err.Error())
+		return report
+	}
+
+	report.Level.applyMetadata(lvl)
+	report.Problems = append(report.Problems, ValidateLevel(lvl)...)
+	if level.Spawn != nil {
+		if problem := checkSpawn(lvl, *level.Spawn); problem != "" {
+			report.Problems = append(report.Problems, "manifest "+problem)
+		}
+	}
+
+	return report
+}
+
+// ValidateLevels opens and checks every level in a list.
+func ValidateLevels(levels []LevelDescriptor) []LevelReport {
+	reports := []LevelReport{}
+	names := make(map[string]bool)
+
+	for _, level := range levels {
+		report := validateLevel(level)
+		if names[level.Name] {
+			report.Problems = append(report.Problems, "duplicate level name")
+		}
+		names[level.Name] = true
+
+		reports = append(reports, report)
+	}
+
+	return reports
+}
+
+// ValidatingSource checks every level read from another source.  Broken
+// levels are left out, or with Strict set, the whole list is rejected (so
+// the server refuses to start, or keeps its current levels on reload).
+type ValidatingSource struct {
+	LevelSource
+	Strict bool
+}
+
+func (src *ValidatingSource) ReadLevels() ([]LevelDescriptor, error) {
+	levels, err := src.LevelSource.ReadLevels()
+	if err != nil {
+		return nil, err
+	}
+
+	valid := []LevelDescriptor{}
+	broken := []string{}
+	for _, report := range ValidateLevels(levels) {
+		if report.OK() {
+			valid = append(valid, report.Level)
+			continue
+		}
+
+		log.Printf("[ERROR] Level %s (%s) is broken: %s",
+			report.Level.Name, report.Level.Path, strings.Join(report.Problems, "; "))
+		broken = append(broken, report.Level.Name)
+	}
+
+	log.Printf("Validated %d levels from %s: %d broken", len(levels), src.LevelSource, len(broken))
+
+	if len(broken) > 0 {
+		if src.Strict {
+			return nil, fmt.Errorf("broken levels: %s", strings.Join(broken, ", "))
+		}
+		log.Printf("Levels unavailable until fixed: %s", strings.Join(broken, ", "))
+	}
+
+	return valid, nil
+}
+
+func runValidate(args []string) error {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	manifest := flags.String("manifest", "", "Validate the levels in this manifest file")
+	scan := flags.String("scan", "", "Validate every level found in these comma-separated directories")
+	format := flags.String("format", "", "Level format of the files given as arguments (detected if not set)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s validate [-manifest file | -scan dirs | level...]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	var levels []LevelDescriptor
+	var err error
+	switch {
+	case *manifest != "" && *scan == "" && flags.NArg() == 0:
+		levels, err = (&ManifestSource{*manifest}).ReadLevels()
+	case *scan != "" && *manifest == "" && flags.NArg() == 0:
+		levels, err = (&ScanSource{Dirs: strings.Split(*scan, ",")}).ReadLevels()
+	case *manifest == "" && *scan == "" && flags.NArg() > 0:
+		for _, path := range flags.Args() {
+			levels = append(levels, LevelDescriptor{Name: path, Path: path, Format: *format})
+		}
+	default:
+		flags.Usage()
+		return errors.New("expected one of -manifest, -scan or level files")
+	}
+	if err != nil {
+		return err
+	}
+
+	broken := 0
+	for _, report := range ValidateLevels(levels) {
+		if report.OK() {
+			fmt.Printf("OK      %s (%s)\n", report.Level.Name, report.Level.Path)
+			continue
+		}
+
+		broken++
+		fmt.Printf("BROKEN  %s (%s)\n", report.Level.Name, report.Level.Path)
+		for _, problem := range report.Problems {
+			fmt.Printf("        %s\n", problem)
+		}
+	}
+	fmt.Printf("%d levels, %d broken\n", len(levels), broken)
+
+	if broken > 0 {
+		return fmt.Errorf("%d broken levels", broken)
+	}
+
+	return nil
+}
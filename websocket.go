@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The browser version of ClassiCube connects over WebSocket, sending the
+// usual Classic packets in binary frames.  Both kinds of connection share a
+// port: a Classic client's first byte is always 0x00 (ClientHello), while a
+// WebSocket handshake starts with "GET".  See RFC 6455.
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const websocketProtocol = "ClassiCube"
+
+// Clients only ever send small packets
+const websocketMaxPayload = 64 << 10
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+)
+
+var ErrNotWebSocket = errors.New("not a WebSocket handshake")
+
+// bufferedConn is a connection that has already had some of its input read
+// into r.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(buf []byte) (int, error) {
+	return c.r.Read(buf)
+}
+
+// acceptTransport works out whether conn is a plain Classic connection or a
+// WebSocket one, and returns a connection that reads and writes Classic
+// packets either way.
+func acceptTransport(conn net.Conn) (net.Conn, error) {
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	r := bufio.NewReader(conn)
+
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] != 'G' {
+		return &bufferedConn{conn, r}, nil
+	}
+
+	return acceptWebSocket(conn, r)
+}
+
+func acceptWebSocket(conn net.Conn, r *bufio.Reader) (net.Conn, error) {
+	req, err := http.ReadRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if req.Method != "GET" ||
+		!headerContains(req.Header, "Connection", "upgrade") ||
+		!headerContains(req.Header, "Upgrade", "websocket") ||
+		key == "" {
+		writeHTTPError(conn, http.StatusBadRequest, "This is a Minecraft Classic server")
+		return nil, ErrNotWebSocket
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		fmt.Fprintf(conn, "HTTP/1.1 426 Upgrade Required\r\nSec-WebSocket-Version: 13\r\nContent-Length: 0\r\n\r\n")
+		return nil, errors.New("unsupported WebSocket version")
+	}
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n"
+	if headerContains(req.Header, "Sec-WebSocket-Protocol", websocketProtocol) {
+		response += "Sec-WebSocket-Protocol: " + websocketProtocol + "\r\n"
+	}
+	response += "\r\n"
+
+	if _, err := io.WriteString(conn, response); err != nil {
+		return nil, err
+	}
+
+	return &wsConn{Conn: conn, r: r}, nil
+}
+
+// headerContains reports whether any of the comma-separated values of a
+// header match value, ignoring case.
+func headerContains(h http.Header, name, value string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), value) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func writeHTTPError(w io.Writer, status int, message string) {
+	fmt.Fprintf(w, "HTTP/1.1 %d %s\r\nContent-Type: text/plain\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s",
+		status, http.StatusText(status), len(message), message)
+}
+
+// wsConn carries a byte stream over a WebSocket.  Each Write is sent as a
+// single binary frame; control frames are handled as they are read.
+type wsConn struct {
+	net.Conn
+	r *bufio.Reader
+
+	// Unread payload of the current data frame
+	remaining int64
+	mask      [4]byte
+	maskPos   int
+
+	// Pongs and close frames are sent from the reading goroutine.  Closing
+	// the connection doesn't send a close frame, since that would mean
+	// waiting for a (possibly stuck) writer.
+	writeMutex sync.Mutex
+}
+
+func (c *wsConn) Read(buf []byte) (int, error) {
+	for c.remaining == 0 {
+		if err := c.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	if int64(len(buf)) > c.remaining {
+		buf = buf[:c.remaining]
+	}
+	n, err := c.r.Read(buf)
+	for i := 0; i < n; i++ {
+		buf[i] ^= c.mask[c.maskPos]
+		c.maskPos = (c.maskPos + 1) % 4
+	}
+	c.remaining -= int64(n)
+
+	return n, err
+}
+
+// nextFrame reads frame headers until a data frame with a payload is found,
+// answering any control frames along the way.
+func (c *wsConn) nextFrame() error {
+	var header [2]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return err
+	}
+
+	opcode := header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	length := int64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+
+	if !masked {
+		return errors.New("websocket: client frame is not masked")
+	}
+	if length < 0 || length > websocketMaxPayload {
+		return errors.New("websocket: frame too large")
+	}
+	if _, err := io.ReadFull(c.r, c.mask[:]); err != nil {
+		return err
+	}
+	c.maskPos = 0
+
+	switch opcode {
+	case wsOpContinuation, wsOpBinary, wsOpText:
+		c.remaining = length
+		return nil
+	case wsOpPing, wsOpPong, wsOpClose:
+		if length > 125 {
+			return errors.New("websocket: control frame too large")
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.r, payload); err != nil {
+			return err
+		}
+		for i := range payload {
+			payload[i] ^= c.mask[i%4]
+		}
+
+		if opcode == wsOpPing {
+			return c.writeFrame(wsOpPong, payload)
+		} else if opcode == wsOpClose {
+			// 1000 = normal closure
+			c.writeFrame(wsOpClose, []byte{0x03, 0xe8})
+			return io.EOF
+		}
+		return nil
+	default:
+		return fmt.Errorf("websocket: unknown opcode 0x%x", opcode)
+	}
+}
+
+func (c *wsConn) Write(buf []byte) (int, error) {
+	if err := c.writeFrame(wsOpBinary, buf); err != nil {
+		return 0, err
+	}
+
+	return len(buf), nil
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | opcode
+	switch {
+	case len(payload) < 126:
+		header[1] = byte(len(payload))
+	case len(payload) <= 0xffff:
+		header[1] = 126
+		header = append(header, byte(len(payload)>>8), byte(len(payload)))
+	default:
+		header[1] = 127
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(len(payload)))
+		header = append(header, ext[:]...)
+	}
+
+	// Sent with a single writev where possible
+	frame := net.Buffers{header, payload}
+	_, err := frame.WriteTo(c.Conn)
+	return err
+}